@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"finalProjectAdvancedP/internal/data"
+	"finalProjectAdvancedP/internal/validator"
+	"fmt"
+	"net/http"
+)
+
+// maxBatchOperations is the most operations accepted in one batch.
+const maxBatchOperations = 100
+
+// A batchOperation is one operation as sent by the client. Version is optional: if it is
+// given, the operation only goes ahead if the book is still at that version.
+type batchOperation struct {
+	Op      string     `json:"op"`
+	ID      int64      `json:"id"`
+	Version *int32     `json:"version"`
+	Book    *bookPatch `json:"book"`
+}
+
+// A batchResult is the outcome of one operation. Error has the same shape as the body
+// of a failedValidationResponse(), mapping the field at fault to a message.
+type batchResult struct {
+	Index  int               `json:"index"`
+	Status int               `json:"status"`
+	Book   *data.Book        `json:"book,omitempty"`
+	Error  map[string]string `json:"error,omitempty"`
+}
+
+// The batchBooksHandler() creates, updates and deletes a list of books in one request.
+// By default the batch is atomic: if any operation fails, none of them is applied. With
+// "atomic": false each operation stands on its own. The response holds the status and
+// error of every operation, in the order they were sent.
+func (app *application) batchBooksHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Atomic     *bool            `json:"atomic"`
+		Operations []batchOperation `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	atomic := input.Atomic == nil || *input.Atomic
+
+	v := validator.New()
+	v.Check(len(input.Operations) > 0, "operations", "must contain at least 1 operation")
+	v.Check(len(input.Operations) <= maxBatchOperations, "operations", fmt.Sprintf("must not contain more than %d operations", maxBatchOperations))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Check every operation before any of them is run. The operations which pass are
+	// handed to the model, and the others get their result straight away.
+	results := make([]batchResult, len(input.Operations))
+	ops := make([]*data.BookOperation, len(input.Operations))
+	seen := make(map[int64]bool)
+	var pending []*data.BookOperation
+	failed := -1
+
+	for i, in := range input.Operations {
+		results[i].Index = i
+
+		op, err := app.prepareBatchOperation(in, taxonomy, seen)
+		if err != nil {
+			results[i].Status, results[i].Error = app.batchError(r, err, in.Version != nil)
+			if failed < 0 {
+				failed = i
+			}
+			continue
+		}
+		ops[i] = op
+		pending = append(pending, op)
+	}
+
+	// An atomic batch with an invalid operation isn't run at all.
+	if (atomic && failed < 0) || (!atomic && len(pending) > 0) {
+		err = app.models.Books.Batch(pending, atomic, app.contextGetUser(r).ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	for i, op := range ops {
+		if op == nil {
+			continue
+		}
+		if op.Err != nil {
+			results[i].Status, results[i].Error = app.batchError(r, op.Err, input.Operations[i].Version != nil)
+			if failed < 0 {
+				failed = i
+			}
+			continue
+		}
+		results[i].Status = http.StatusOK
+		switch op.Op {
+		case data.BatchCreate:
+			results[i].Status = http.StatusCreated
+			results[i].Book = op.Book
+		case data.BatchUpdate:
+			results[i].Book = op.Book
+		}
+	}
+
+	// When an atomic batch fails, the operations which were undone or never run are
+	// reported as 424 Failed Dependency, and the batch as a whole gets the status of the
+	// operation which failed.
+	status := http.StatusOK
+	if atomic && failed >= 0 {
+		status = results[failed].Status
+		for i := range results {
+			if results[i].Error == nil {
+				results[i].Status = http.StatusFailedDependency
+				results[i].Book = nil
+				results[i].Error = map[string]string{"op": fmt.Sprintf("not applied, as operation %d failed", failed)}
+			}
+		}
+	}
+
+	err = app.writeJSON(w, r, status, envelope{"atomic": atomic, "results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// A batchValidationError holds the validation errors of one operation in a batch.
+type batchValidationError map[string]string
+
+func (e batchValidationError) Error() string {
+	return "batch operation failed validation"
+}
+
+// The prepareBatchOperation() helper checks an operation and turns it into a
+// data.BookOperation. Updates are applied to the book as it is now, so that the model
+// only has to save it. The ids of the books already in the batch are kept in seen, as a
+// book may only be touched once.
+func (app *application) prepareBatchOperation(in batchOperation, taxonomy *data.Taxonomy, seen map[int64]bool) (*data.BookOperation, error) {
+	v := validator.New()
+	v.Check(validator.PermittedValue(in.Op, data.BatchCreate, data.BatchUpdate, data.BatchDelete), "op", "must be create, update or delete")
+	if in.Op == data.BatchCreate {
+		v.Check(in.ID == 0, "id", "must not be provided for create")
+		v.Check(in.Version == nil, "version", "must not be provided for create")
+	} else {
+		v.Check(in.ID > 0, "id", "must be a positive integer")
+		v.Check(!seen[in.ID], "id", "must not appear in more than one operation")
+		seen[in.ID] = true
+	}
+	if in.Version != nil {
+		v.Check(*in.Version > 0, "version", "must be a positive integer")
+	}
+	if in.Op == data.BatchDelete {
+		v.Check(in.Book == nil, "book", "must not be provided for delete")
+	} else {
+		v.Check(in.Book != nil, "book", "must be provided")
+	}
+	if !v.Valid() {
+		return nil, batchValidationError(v.Errors)
+	}
+
+	op := &data.BookOperation{Op: in.Op, Book: &data.Book{ID: in.ID}}
+
+	switch in.Op {
+	case data.BatchDelete:
+		if in.Version != nil {
+			op.Book.Version = *in.Version
+		}
+		return op, nil
+	case data.BatchUpdate:
+		book, err := app.models.Books.GetForUpdate(in.ID)
+		if err != nil {
+			return nil, err
+		}
+		if in.Version != nil && *in.Version != book.Version {
+			return nil, data.ErrEditConflict
+		}
+		op.Book = book
+	}
+
+	in.Book.apply(op.Book)
+
+	if in.Book.AuthorIDs != nil {
+		authors, err := app.lookupAuthors(v, in.Book.AuthorIDs)
+		if err != nil {
+			return nil, err
+		}
+		op.Book.Authors = authors
+	}
+
+	if data.ValidateBook(v, op.Book, taxonomy); !v.Valid() {
+		return nil, batchValidationError(v.Errors)
+	}
+	return op, nil
+}
+
+// The batchError() helper returns the status code and error map to report for an
+// operation which failed. An edit conflict on an operation which named a version is a
+// failed precondition, as with If-Match.
+func (app *application) batchError(r *http.Request, err error, versioned bool) (int, map[string]string) {
+	var validationErr batchValidationError
+	var constraintErr *data.ConstraintError
+	switch {
+	case errors.As(err, &validationErr):
+		return http.StatusUnprocessableEntity, validationErr
+	case errors.Is(err, data.ErrRecordNotFound):
+		return http.StatusNotFound, map[string]string{"id": "must refer to an existing book"}
+	case errors.Is(err, data.ErrEditConflict) && versioned:
+		return http.StatusPreconditionFailed, map[string]string{"version": "the book has been changed since this version"}
+	case errors.Is(err, data.ErrEditConflict):
+		return http.StatusConflict, map[string]string{"version": "the book was changed by another request, please try again"}
+	case errors.As(err, &constraintErr):
+		status := http.StatusUnprocessableEntity
+		if errors.Is(constraintErr, data.ErrUniqueViolation) {
+			status = http.StatusConflict
+		}
+		return status, map[string]string{constraintErr.Field: constraintErr.Message}
+	default:
+		app.logError(r, err)
+		return http.StatusInternalServerError, map[string]string{"op": "the server encountered a problem and could not process this operation"}
+	}
+}
@@ -6,17 +6,27 @@ import (
 	"finalProjectAdvancedP/internal/validator"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
 )
 
 func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request) {
+	// Authors can be given either as a list of author ids, or (for compatibility) as
+	// the old author name string.
 	var input struct {
-		Title  string   `json:"title"`
-		Year   int32    `json:"year"`
-		Author string   `json:"author"`
-		Genres []string `json:"genres"`
-		Price  uint64   `json:"price"`
-		Email  string   `json:"email"`
+		Title       string   `json:"title"`
+		Year        int32    `json:"year"`
+		Author      string   `json:"author"`
+		AuthorIDs   []int64  `json:"author_ids"`
+		PublisherID *int64   `json:"publisher_id"`
+		ISBN10      string   `json:"isbn10"`
+		ISBN13      string   `json:"isbn13"`
+		Genres      []string `json:"genres"`
+		Price       uint64   `json:"price"`
+		Email       string   `json:"email"`
 	}
 
 	// here we use app.readJSON() method to read request from body and decode it into input struct
@@ -36,14 +46,31 @@ func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	book := &data.Book{
-		Title:  input.Title,
-		Year:   input.Year,
-		Author: input.Author,
-		Genres: input.Genres,
-		Price:  input.Price,
+		Title:       input.Title,
+		Year:        input.Year,
+		Author:      input.Author,
+		Genres:      input.Genres,
+		Price:       input.Price,
+		PublisherID: input.PublisherID,
+		ISBN10:      validator.NormalizeISBN(input.ISBN10),
+		ISBN13:      validator.NormalizeISBN(input.ISBN13),
+	}
+
+	if input.AuthorIDs != nil {
+		book.Authors, err = app.lookupAuthors(v, input.AuthorIDs)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 	}
 
-	if data.ValidateBook(v, book); !v.Valid() {
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateBook(v, book, taxonomy); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
@@ -60,9 +87,15 @@ func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	if user.Admin != false {
-		err = app.models.Books.Insert(book)
+		err = app.models.Books.Insert(book, app.actorID(r, user))
 		if err != nil {
-			app.notAdminErrorResponse(w, r, err)
+			var constraintErr *data.ConstraintError
+			switch {
+			case errors.As(err, &constraintErr):
+				app.constraintViolationResponse(w, r, constraintErr)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
 			return
 		}
 	} else {
@@ -71,10 +104,11 @@ func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/books/%d", book.ID))
+	headers.Set("ETag", bookETag(book))
 
 	// Write a JSON response with a 201 Created status code, the book data in the
 	// response body, and the Location header.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"book": book}, headers)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"book": book}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -89,7 +123,19 @@ func (app *application) showBookHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	book, err := app.models.Books.Get(id)
+	v := validator.New()
+	includeDeleted := app.readIncludeDeleted(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var book *data.Book
+	if includeDeleted {
+		book, err = app.models.Books.GetIncludingDeleted(id)
+	} else {
+		book, err = app.models.Books.Get(id)
+	}
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -100,7 +146,12 @@ func (app *application) showBookHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
+	app.setCacheControl(w, includeDeleted)
+	if app.notModified(w, r, bookETag(book)) {
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"book": book}, nil)
 	if err != nil {
 		app.logger.PrintError(err, nil)
 		// server runtime error helper
@@ -108,6 +159,97 @@ func (app *application) showBookHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// The showBookByISBNHandler() looks up a book by its ISBN-10 or ISBN-13, which may be
+// written with or without hyphens.
+func (app *application) showBookByISBNHandler(w http.ResponseWriter, r *http.Request) {
+	isbn := validator.NormalizeISBN(httprouter.ParamsFromContext(r.Context()).ByName("isbn"))
+
+	v := validator.New()
+	v.Check(validator.ValidISBN10(isbn) || validator.ValidISBN13(isbn), "isbn", "must be a valid ISBN-10 or ISBN-13")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	book, err := app.models.Books.GetByISBN(isbn)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.setCacheControl(w, false)
+	if app.notModified(w, r, bookETag(book)) {
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"book": book}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// A bookPatch holds the fields which can be changed on an existing book. Fields left
+// out of the JSON are nil, and leave the book as it is.
+type bookPatch struct {
+	Title       *string  `json:"title"`
+	Year        *int32   `json:"year"`
+	Author      *string  `json:"author"`
+	AuthorIDs   []int64  `json:"author_ids"`
+	PublisherID *int64   `json:"publisher_id"`
+	ISBN10      *string  `json:"isbn10"`
+	ISBN13      *string  `json:"isbn13"`
+	Genres      []string `json:"genres"`
+	Price       *uint64  `json:"price"`
+}
+
+// The apply() method copies the fields which were given onto the book. The authors
+// named by AuthorIDs are left for the caller to look up.
+func (patch *bookPatch) apply(book *data.Book) {
+	if patch.Title != nil {
+		book.Title = *patch.Title
+	}
+	// We also do the same for the other fields in the input struct.
+	if patch.Year != nil {
+		book.Year = *patch.Year
+	}
+	// A new author string replaces the book's authors with the single author of that
+	// name, while author_ids replaces them with the given authors.
+	if patch.Author != nil {
+		book.Author = *patch.Author
+		book.Authors = nil
+	}
+	if patch.Genres != nil {
+		book.Genres = patch.Genres // Note that we don't need to dereference a slice.
+	}
+	if patch.Price != nil {
+		book.Price = *patch.Price
+	}
+	// A publisher_id of 0 removes the book's publisher.
+	if patch.PublisherID != nil {
+		book.PublisherID = patch.PublisherID
+		if *patch.PublisherID == 0 {
+			book.PublisherID = nil
+		}
+	}
+	// The two ISBNs are always replaced together, so that they can't end up referring
+	// to different books. Whichever one is left out is derived from the other, and an
+	// empty string removes it.
+	if patch.ISBN10 != nil || patch.ISBN13 != nil {
+		book.ISBN10, book.ISBN13 = "", ""
+		if patch.ISBN10 != nil {
+			book.ISBN10 = validator.NormalizeISBN(*patch.ISBN10)
+		}
+		if patch.ISBN13 != nil {
+			book.ISBN13 = validator.NormalizeISBN(*patch.ISBN13)
+		}
+	}
+}
+
 func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the book ID from the URL.
 	id, err := app.readIDParam(r)
@@ -116,7 +258,9 @@ func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	book, err := app.models.Books.Get(id)
+	// Read the book from the primary, as we're about to write it back there and need
+	// the latest version number.
+	book, err := app.models.Books.GetForUpdate(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -133,15 +277,17 @@ func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request
 			return
 		}
 	}
+	// The standard If-Match header does the same job. Update() only saves the book if it
+	// is still at the version we've just checked, so a change made in between is caught
+	// too.
+	if !app.checkIfMatch(w, r, bookETag(book)) {
+		return
+	}
 
 	// Declare an input struct to hold the expected data from the client.
 	var input struct {
-		Title  *string  `json:"title"`
-		Year   *int32   `json:"year"`
-		Author *string  `json:"author"`
-		Genres []string `json:"genres"`
-		Price  *uint64  `json:"price"`
-		Email  *string  `json:"email"`
+		bookPatch
+		Email *string `json:"email"`
 	}
 
 	// Read the JSON request body data into the input struct.
@@ -151,26 +297,25 @@ func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if input.Title != nil {
-		book.Title = *input.Title
-	}
-	// We also do the same for the other fields in the input struct.
-	if input.Year != nil {
-		book.Year = *input.Year
-	}
-	if input.Author != nil {
-		book.Author = *input.Author
-	}
-	if input.Genres != nil {
-		book.Genres = input.Genres // Note that we don't need to dereference a slice.
-	}
-	if input.Price != nil {
-		book.Price = *input.Price
-	}
+	input.apply(book)
 
 	v := validator.New()
 
-	if data.ValidateBook(v, book); !v.Valid() {
+	if input.AuthorIDs != nil {
+		book.Authors, err = app.lookupAuthors(v, input.AuthorIDs)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateBook(v, book, taxonomy); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
@@ -190,17 +335,28 @@ func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 	if user.Admin != false {
-		err = app.models.Books.Update(book)
-		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
-		default:
-			app.notAdminErrorResponse(w, r, err)
+		err = app.models.Books.Update(book, app.actorID(r, user))
+		if err != nil {
+			var constraintErr *data.ConstraintError
+			switch {
+			case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+				app.preconditionFailedResponse(w, r)
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			case errors.As(err, &constraintErr):
+				app.constraintViolationResponse(w, r, constraintErr)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
 		}
+	} else {
+		app.notAdminErrorResponse(w, r, errors.New("non-admin user attempted to update a book"))
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
+	w.Header().Set("ETag", bookETag(book))
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"book": book}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -241,11 +397,33 @@ func (app *application) deleteBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 	if user.Admin != false {
-		err = app.models.Books.Delete(id)
+		// With an If-Match header, the book is only deleted if it is still at the
+		// version named there.
+		var version int32
+		if r.Header.Get("If-Match") != "" {
+			book, err := app.models.Books.GetForUpdate(id)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.notFoundResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+			if !app.checkIfMatch(w, r, bookETag(book)) {
+				return
+			}
+			version = book.Version
+		}
+
+		err = app.models.Books.Delete(id, version, app.actorID(r, user))
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
 				app.notFoundResponse(w, r)
+			case errors.Is(err, data.ErrEditConflict):
+				app.preconditionFailedResponse(w, r)
 			default:
 				app.serverErrorResponse(w, r, err)
 			}
@@ -256,56 +434,229 @@ func (app *application) deleteBookHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// Return a 200 OK status code along with a success message.
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "book successfully deleted"}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "book successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The actorID() helper returns the id of the user to record in the revision history as
+// making a change: the authenticated user, or else the admin who was identified by their
+// email address in the request body.
+func (app *application) actorID(r *http.Request, admin *data.User) int64 {
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return admin.ID
+	}
+	return user.ID
+}
+
+// The restoreBookHandler() brings back a book which has been deleted but not yet purged.
+func (app *application) restoreBookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	book, err := app.models.Books.Restore(id, app.contextGetUser(r).ID)
+	if err != nil {
+		var constraintErr *data.ConstraintError
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.As(err, &constraintErr):
+			app.constraintViolationResponse(w, r, constraintErr)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", bookETag(book))
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"book": book}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// The purgeDeletedBooks() method permanently removes the books which were deleted more
+// than the retention period ago, every purge interval. It runs for the lifetime of the
+// application, unless the purge interval is zero.
+func (app *application) purgeDeletedBooks() {
+	if app.config.books.purgeInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(app.config.books.purgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purged, err := app.models.Books.Purge(time.Now().Add(-app.config.books.retention))
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+		if purged > 0 {
+			app.logger.PrintInfo("purged deleted books", map[string]string{"books": strconv.FormatInt(purged, 10)})
+		}
+	}
+}
+
+// The readIncludeDeleted() helper reads the include_deleted query string value, which
+// lets admins see deleted books. Other users may not set it.
+func (app *application) readIncludeDeleted(r *http.Request, v *validator.Validator) bool {
+	includeDeleted := app.readBool(r.URL.Query(), "include_deleted", false, v)
+	if includeDeleted && !app.contextGetUser(r).Admin {
+		v.AddError("include_deleted", "can only be used by admins")
+		return false
+	}
+	return includeDeleted
+}
+
 func (app *application) listBooksHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		data.BookSearch
 		data.Filters
+		Facets []string
 	}
 
 	v := validator.New()
 
 	qs := r.URL.Query()
 
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.BookSearch = app.readBookSearch(qs, v)
+	input.BookSearch.IncludeDeleted = app.readIncludeDeleted(r, v)
+	// Read the list of facets to count alongside the results, if any.
+	input.Facets = app.readCSV(qs, "facets", []string{})
 
 	// Read the page and page_size query string values into the embedded struct.
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	// Read the sort query string value into the embedded struct.
 	input.Filters.Sort = app.readString(qs, "sort", "id")
+	// Read the keyset pagination cursor, if any, along with the key used to verify it.
+	// Clients can also opt out of counting the total number of records.
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.CursorKey = app.config.cursor.secret
+	input.Filters.SkipTotal = !app.readBool(qs, "include_total", true, v)
 
 	// Add the supported sort values for this endpoint to the sort safelist.
-	input.Filters.SortSafelist = []string{"id", "title", "year", "price", "-id", "-title", "-year", "-price"}
-	// Execute the validation checks on the Filters struct and send a response
-	// containing the errors if necessary.
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+	input.Filters.SortSafelist = []string{"id", "title", "year", "price", "relevance", "-id", "-title", "-year", "-price"}
+	// Execute the validation checks on the search criteria and the Filters struct and
+	// send a response containing the errors if necessary.
+	data.ValidateBookSearch(v, input.BookSearch)
+	data.ValidateFilters(v, input.Filters)
+	data.ValidateFacets(v, input.Facets)
+	if input.Filters.Sort == "relevance" {
+		v.Check(input.BookSearch.Query != "", "sort", "relevance can only be used together with q")
+		v.Check(input.Filters.Cursor == "", "cursor", "cannot be used with relevance sort")
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
+	// Accept the metadata struct as a return value.
+	books, metadata, err := app.models.Books.GetAll(input.BookSearch, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Include the metadata in the response envelope.
+	env := envelope{"books": books, "metadata": metadata}
+
+	// If the client asked for facets, count them over the same search and include them
+	// in the envelope too.
+	if len(input.Facets) > 0 {
+		facets, err := app.models.Books.Facets(input.BookSearch, input.Facets)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["facets"] = facets
+	}
+
+	// Any change to a book is recorded in its revision history, and authors, publishers
+	// and genres record when they were last edited, so between them they tell us when
+	// the catalog last changed.
+	lastModified, err := app.models.Books.LastModified()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.setCacheControl(w, input.BookSearch.IncludeDeleted)
+	err = app.writeListJSON(w, r, env, lastModified)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The lookupAuthors() helper fetches the authors with the given ids, so that they can be
+// assigned to a book. If the ids are invalid or any of the authors doesn't exist, it
+// records an error in the provided Validator instance and returns nil.
+func (app *application) lookupAuthors(v *validator.Validator, ids []int64) ([]*data.Author, error) {
+	v.Check(len(ids) > 0, "author_ids", "must contain at least 1 author")
+	v.Check(validator.Unique(ids), "author_ids", "must not contain duplicate values")
 	if !v.Valid() {
+		return nil, nil
+	}
+
+	authors, err := app.models.Authors.GetMany(ids)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("author_ids", "must refer to existing authors")
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+	return authors, nil
+}
+
+// The readBookSearch() helper reads the catalog search criteria from the query string,
+// recording an error in the provided Validator instance for any malformed number.
+func (app *application) readBookSearch(qs url.Values, v *validator.Validator) data.BookSearch {
+	return data.BookSearch{
+		Title:    app.readString(qs, "title", ""),
+		Author:   app.readString(qs, "author", ""),
+		Query:    app.readString(qs, "q", ""),
+		Genres:   app.readCSV(qs, "genres", []string{}),
+		MinPrice: int64(app.readInt(qs, "min_price", 0, v)),
+		MaxPrice: int64(app.readInt(qs, "max_price", 0, v)),
+		MinYear:  app.readInt(qs, "min_year", 0, v),
+		MaxYear:  app.readInt(qs, "max_year", 0, v),
+		// With fuzzy=true, the text filters also match similar spellings, as similar as
+		// the similarity parameter asks for.
+		Fuzzy:      app.readBool(qs, "fuzzy", false, v),
+		Similarity: app.readFloat(qs, "similarity", app.config.search.similarity, v),
+	}
+}
+
+// The suggestBooksHandler() returns the books best matching a partially typed search,
+// for use in autocomplete. Matches are typo-tolerant, and come with highlights showing
+// which words matched.
+func (app *application) suggestBooksHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	q := app.readString(qs, "q", "")
+	limit := app.readInt(qs, "limit", 10, v)
+
+	if data.ValidateSuggestQuery(v, q, limit); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	// Dump the contents of the input struct in a HTTP response.
-	fmt.Fprintf(w, "%+v\n", input)
 
-	// Accept the metadata struct as a return value.
-	books, metadata, err := app.models.Books.GetAll(input.Title, input.Genres, input.Filters)
+	suggestions, err := app.models.Books.Suggest(q, limit, app.config.search.suggestSimilarity)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// Include the metadata in the response envelope.
-	err = app.writeJSON(w, http.StatusOK, envelope{"books": books, "metadata": metadata}, nil)
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"suggestions": suggestions}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
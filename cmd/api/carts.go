@@ -6,6 +6,7 @@ import (
 	"finalProjectAdvancedP/internal/validator"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 var TotalQuantity int64 = 0
@@ -27,12 +28,12 @@ func (app *application) addToCartHandler(w http.ResponseWriter, r *http.Request)
 
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if user.Activated != true {
-		app.errorResponse(w, r, 404, "Email is not activated or does not exist")
+		app.errorResponse(w, r, 404, "user_not_found", "Email is not activated or does not exist")
 		return
 	}
 
 	if user.Activated != true {
-		app.errorResponse(w, r, 404, "Email is not activated or does not exist")
+		app.errorResponse(w, r, 404, "user_not_found", "Email is not activated or does not exist")
 		return
 	}
 
@@ -68,13 +69,19 @@ func (app *application) addToCartHandler(w http.ResponseWriter, r *http.Request)
 
 	err = app.models.Carts.Insert(cart)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		var constraintErr *data.ConstraintError
+		switch {
+		case errors.As(err, &constraintErr):
+			app.constraintViolationResponse(w, r, constraintErr)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/cart/%d", cart.Email))
-	err = app.writeJSON(w, http.StatusCreated, envelope{"cart": cart}, headers)
+	headers.Set("Location", fmt.Sprintf("/v1/cart/%s", cart.Email))
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"cart": cart}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -113,7 +120,7 @@ func (app *application) deleteBookFromCartHandler(w http.ResponseWriter, r *http
 	}
 
 	if user.Activated != true {
-		app.errorResponse(w, r, 404, "Email is not activated or does not exist")
+		app.errorResponse(w, r, 404, "user_not_found", "Email is not activated or does not exist")
 		return
 	}
 
@@ -135,7 +142,7 @@ func (app *application) deleteBookFromCartHandler(w http.ResponseWriter, r *http
 	}
 
 	// Return a 200 OK status code along with a success message.
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "book successfully deleted from the Cart"}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "book successfully deleted from the Cart"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -150,7 +157,7 @@ func (app *application) listBooksInCartHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 	// Include the metadata in the response envelope.
-	err = app.writeJSON(w, http.StatusOK, envelope{"books": books}, nil)
+	err = app.writeListJSON(w, r, envelope{"books": books}, time.Time{})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -204,7 +211,7 @@ func (app *application) orderBookHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Return a 200 OK status code along with a success message.
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "book was successfully ordered"}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "book was successfully ordered"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
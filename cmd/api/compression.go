@@ -0,0 +1,294 @@
+package main
+
+import (
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// brotliLevel is the brotli quality used for responses. The higher levels compress a
+// little better, but are far too slow to use on every response.
+const brotliLevel = 4
+
+// Compressors are reused between responses, as they are expensive to set up.
+var (
+	gzipWriters   = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+	brotliWriters = sync.Pool{New: func() any { return brotli.NewWriterLevel(nil, brotliLevel) }}
+)
+
+// The compress() middleware compresses responses with brotli or gzip, whichever the
+// client prefers of those it accepts in its Accept-Encoding header. Responses smaller
+// than the configured minimum size are sent as they are, as compressing them gains
+// little and costs time on both ends.
+//
+// A strong ETag identifies the exact bytes of a response, so a compressed response has
+// the encoding added to its ETag, as in "12-gzip". The suffix is taken off again in the
+// If-Match and If-None-Match headers of later requests, so that the handlers only ever
+// deal with their own ETags.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response depends on the Accept-Encoding header whether or not it ends up
+		// compressed, so caches must know to keep a copy for each encoding.
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		var validatorEncoding string
+		for _, name := range []string{"If-Match", "If-None-Match"} {
+			if values := r.Header.Values(name); len(values) > 0 {
+				header, encoding := stripETagEncodings(strings.Join(values, ","))
+				r.Header.Set(name, header)
+				if name == "If-None-Match" {
+					validatorEncoding = encoding
+				}
+			}
+		}
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter:    w,
+			encoding:          encoding,
+			minSize:           app.config.compression.minSize,
+			validatorEncoding: validatorEncoding,
+		}
+		next.ServeHTTP(cw, r)
+		// This isn't deferred, so that a panic is left to recoverPanic() to deal with
+		// before anything has been sent.
+		cw.Close()
+	})
+}
+
+// The negotiateEncoding() function returns "br" or "gzip", whichever is given the
+// higher weight in an Accept-Encoding header, with brotli winning ties. It returns ""
+// if the client accepts neither.
+func negotiateEncoding(header string) string {
+	weights := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			weights[name] = qValue(params)
+		}
+	}
+
+	best, bestWeight := "", 0.0
+	for _, name := range []string{"br", "gzip"} {
+		weight, ok := weights[name]
+		if !ok {
+			weight = weights["*"]
+		}
+		if weight > bestWeight {
+			best, bestWeight = name, weight
+		}
+	}
+	return best
+}
+
+// The qValue() function returns the weight given by the q parameter among the
+// semicolon-separated parameters of an Accept or Accept-Encoding header element. The
+// weight is 1 if there is no q parameter, and 0 if it is invalid.
+func qValue(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(param, "=")
+		if strings.ToLower(strings.TrimSpace(name)) != "q" {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+		return q
+	}
+	return 1
+}
+
+// The encodingETag() function returns the ETag of a response compressed with the given
+// encoding. Weak ETags are left as they are, as the weak comparison doesn't care about
+// the encoding.
+func encodingETag(etag, encoding string) string {
+	if len(etag) < 2 || !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + encoding + `"`
+}
+
+// The stripETagEncodings() function takes the encoding suffixes added by encodingETag()
+// off the entity tags in an If-Match or If-None-Match header. It also returns the
+// encoding of the last tag which had one.
+func stripETagEncodings(header string) (string, string) {
+	var encoding string
+	etags := etagList(header)
+	for i, etag := range etags {
+		if !strings.HasPrefix(etag, `"`) {
+			continue
+		}
+		for _, enc := range []string{"br", "gzip"} {
+			if strings.HasSuffix(etag, "-"+enc+`"`) {
+				etags[i] = strings.TrimSuffix(etag, "-"+enc+`"`) + `"`
+				encoding = enc
+				break
+			}
+		}
+	}
+	return strings.Join(etags, ", "), encoding
+}
+
+// A compressWriter holds a response back until it has at least minSize bytes of the
+// body, and then sends it on compressed. If the response ends before that, it is sent
+// on as it is. validatorEncoding is the encoding of the client's copy of the response,
+// according to its If-None-Match header, which a 304 Not Modified response keeps.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding          string
+	minSize           int
+	validatorEncoding string
+
+	status      int
+	wroteHeader bool
+	started     bool           // whether the status line has been passed on
+	buf         []byte         // body held back until we know whether to compress it
+	enc         io.WriteCloser // compressor, when the response is being compressed
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	// Informational responses go straight through, and the final status is still to come.
+	if status < 200 || cw.wroteHeader {
+		if status < 200 {
+			cw.ResponseWriter.WriteHeader(status)
+		}
+		return
+	}
+	cw.status = status
+	cw.wroteHeader = true
+
+	// Responses which can't have a body can be passed on right away.
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.start(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.started {
+		if cw.enc != nil {
+			return cw.enc.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.minSize {
+		err := cw.start(true)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// The start() method passes the status line on, compressing the rest of the response
+// if compress is true and the response is worth compressing, and then sends what has
+// been held back.
+func (cw *compressWriter) start(compress bool) error {
+	cw.started = true
+
+	h := cw.Header()
+	if cw.status == http.StatusNotModified && cw.validatorEncoding == cw.encoding && h.Get("ETag") != "" {
+		h.Set("ETag", encodingETag(h.Get("ETag"), cw.encoding))
+	}
+	if compress && compressible(h) {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" {
+			h.Set("ETag", encodingETag(etag, cw.encoding))
+		}
+
+		switch cw.encoding {
+		case "br":
+			bw := brotliWriters.Get().(*brotli.Writer)
+			bw.Reset(cw.ResponseWriter)
+			cw.enc = bw
+		default:
+			gw := gzipWriters.Get().(*gzip.Writer)
+			gw.Reset(cw.ResponseWriter)
+			cw.enc = gw
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+// The compressible() function reports whether a response with the given headers should
+// be compressed. Images are compressed already, and a response which has an encoding
+// already, or is part of a larger body, is left alone.
+func compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	return !strings.HasPrefix(contentType, "image/")
+}
+
+// The Flush() method sends what has been written so far to the client. The response is
+// compressed from then on whatever its size, as more of it may be on the way.
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.started {
+		cw.start(true)
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// The Close() method sends on anything still held back, and finishes the compressed
+// stream.
+func (cw *compressWriter) Close() error {
+	if !cw.started {
+		// Nothing was written at all, so leave it to net/http to send an empty 200.
+		if !cw.wroteHeader {
+			return nil
+		}
+		cw.start(false)
+	}
+	if cw.enc == nil {
+		return nil
+	}
+
+	err := cw.enc.Close()
+	switch enc := cw.enc.(type) {
+	case *brotli.Writer:
+		enc.Reset(nil)
+		brotliWriters.Put(enc)
+	case *gzip.Writer:
+		enc.Reset(nil)
+		gzipWriters.Put(enc)
+	}
+	cw.enc = nil
+	return err
+}
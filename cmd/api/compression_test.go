@@ -0,0 +1,160 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"GZIP", "gzip"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0.8, gzip;q=0.8", "br"},
+		{"br;q=0, gzip;q=0", ""},
+		{"*", "br"},
+		{"*;q=0.5, gzip;q=0.6", "gzip"},
+		{"br;q=0, *", "gzip"},
+		{"gzip;q=abc", ""},
+		{"gzip;q=2", ""},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.header); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q; want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestQValue(t *testing.T) {
+	tests := []struct {
+		params string
+		want   float64
+	}{
+		{"", 1},
+		{"q=0.5", 0.5},
+		{" Q = 0.25 ", 0.25},
+		{"level=1;q=0", 0},
+		{"q=1.5", 0},
+		{"q=-1", 0},
+		{"q=high", 0},
+	}
+
+	for _, tt := range tests {
+		if got := qValue(tt.params); got != tt.want {
+			t.Errorf("qValue(%q) = %v; want %v", tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestETagEncodings(t *testing.T) {
+	if got := encodingETag(`"12-ab"`, "gzip"); got != `"12-ab-gzip"` {
+		t.Errorf("encodingETag() = %s; want \"12-ab-gzip\"", got)
+	}
+	if got := encodingETag(`W/"abc"`, "br"); got != `W/"abc"` {
+		t.Errorf("encodingETag() of a weak ETag = %s; want it unchanged", got)
+	}
+
+	tests := []struct {
+		header       string
+		want         string
+		wantEncoding string
+	}{
+		{`"12-ab"`, `"12-ab"`, ""},
+		{`"12-ab-gzip"`, `"12-ab"`, "gzip"},
+		{`"11-cd", "12-ab-br"`, `"11-cd", "12-ab"`, "br"},
+		{`W/"abc-gzip"`, `W/"abc-gzip"`, ""},
+		{"*", "*", ""},
+	}
+	for _, tt := range tests {
+		got, encoding := stripETagEncodings(tt.header)
+		if got != tt.want || encoding != tt.wantEncoding {
+			t.Errorf("stripETagEncodings(%s) = %s, %q; want %s, %q", tt.header, got, encoding, tt.want, tt.wantEncoding)
+		}
+	}
+}
+
+// A compressed response must have an ETag of its own, which the client can then
+// revalidate and make changes with.
+func TestCompressETags(t *testing.T) {
+	app := &application{}
+	app.config.compression.minSize = 16
+	body := strings.Repeat(`{"title":"Dune"}`, 10)
+
+	handler := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			if app.checkIfMatch(w, r, `"5-ab"`) {
+				w.WriteHeader(http.StatusNoContent)
+			}
+			return
+		}
+		if app.notModified(w, r, `"5-ab"`) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+
+	send := func(method, acceptEncoding, header, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/v1/books/1", nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		if header != "" {
+			r.Header.Set(header, etag)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := send(http.MethodGet, "gzip", "", "")
+	if got := w.Header().Get("ETag"); got != `"5-ab-gzip"` {
+		t.Fatalf("compressed response ETag = %s; want \"5-ab-gzip\"", got)
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != body {
+		t.Errorf("compressed body = %q; want %q", got, body)
+	}
+
+	if got := send(http.MethodGet, "", "", "").Header().Get("ETag"); got != `"5-ab"` {
+		t.Errorf("uncompressed response ETag = %s; want \"5-ab\"", got)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		acceptEncoding string
+		header         string
+		etag           string
+		wantStatus     int
+		wantETag       string
+	}{
+		{"revalidate compressed copy", http.MethodGet, "gzip", "If-None-Match", `"5-ab-gzip"`, http.StatusNotModified, `"5-ab-gzip"`},
+		{"revalidate uncompressed copy", http.MethodGet, "gzip", "If-None-Match", `"5-ab"`, http.StatusNotModified, `"5-ab"`},
+		{"revalidate stale copy", http.MethodGet, "gzip", "If-None-Match", `"4-cd-gzip"`, http.StatusOK, `"5-ab-gzip"`},
+		{"update with compressed ETag", http.MethodPatch, "gzip", "If-Match", `"5-ab-gzip"`, http.StatusNoContent, ""},
+		{"update with brotli ETag", http.MethodPatch, "", "If-Match", `"5-ab-br"`, http.StatusNoContent, ""},
+		{"update with stale ETag", http.MethodPatch, "gzip", "If-Match", `"4-cd-gzip"`, http.StatusPreconditionFailed, ""},
+	}
+	for _, tt := range tests {
+		w := send(tt.method, tt.acceptEncoding, tt.header, tt.etag)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d; want %d", tt.name, w.Code, tt.wantStatus)
+		}
+		if tt.wantETag != "" && w.Header().Get("ETag") != tt.wantETag {
+			t.Errorf("%s: ETag %s; want %s", tt.name, w.Header().Get("ETag"), tt.wantETag)
+		}
+	}
+}
@@ -14,6 +14,10 @@ type contextKey string
 // in the request context.
 const userContextKey = contextKey("user")
 
+// clientIPContextKey is the key for the client IP address worked out by the
+// resolveClientIP() middleware.
+const clientIPContextKey = contextKey("client_ip")
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
@@ -33,3 +37,21 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// The contextSetClientIP() method returns a new copy of the request with the client IP
+// address added to the context.
+func (app *application) contextSetClientIP(r *http.Request, ip string) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+	return r.WithContext(ctx)
+}
+
+// The contextGetClientIP() method retrieves the client IP address from the request
+// context. Like contextGetUser(), it panics if the resolveClientIP() middleware hasn't
+// run.
+func (app *application) contextGetClientIP(r *http.Request) string {
+	ip, ok := r.Context().Value(clientIPContextKey).(string)
+	if !ok {
+		panic("missing client IP value in request context")
+	}
+	return ip
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"finalProjectAdvancedP/internal/data"
+	"finalProjectAdvancedP/internal/imaging"
+	"finalProjectAdvancedP/internal/storage"
+	"finalProjectAdvancedP/internal/validator"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// coverThumbnails holds the widths of the thumbnails generated for each cover. They are
+// stored next to the original, as <name>.jpg.
+var coverThumbnails = map[string]int{
+	"small":  100,
+	"medium": 300,
+	"large":  600,
+}
+
+// maxCoverPixels limits the dimensions of uploaded covers, so that a small but highly
+// compressed file can't make us allocate an enormous image when decoding it.
+const maxCoverPixels = 40_000_000
+
+// The updateBookCoverHandler accepts a multipart/form-data upload with the image in a
+// "cover" field, stores it along with its thumbnails, and points the book at it. Any
+// previous cover is removed afterwards.
+func (app *application) updateBookCoverHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	book, err := app.models.Books.GetForUpdate(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkIfMatch(w, r, bookETag(book)) {
+		return
+	}
+
+	// Allow a little room above the image size for the rest of the multipart body.
+	maxBytes := app.config.covers.maxBytes
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64<<10)
+
+	err = r.ParseMultipartForm(maxBytes)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, "request_too_large", fmt.Sprintf("cover must not be larger than %d bytes", maxBytes))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("cover")
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("body must contain an image file in the \"cover\" field"))
+		return
+	}
+	defer file.Close()
+
+	original, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(original) > 0, "cover", "must not be empty")
+	v.Check(int64(len(original)) <= maxBytes && header.Size <= maxBytes, "cover", fmt.Sprintf("must not be larger than %d bytes", maxBytes))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Covers are decoded to check that they really are images, going by the file's
+	// contents rather than the Content-Type the client claimed for it, and scaled down
+	// for the thumbnails.
+	img, contentType, err := imaging.Decode(original, maxCoverPixels)
+	switch {
+	case errors.Is(err, imaging.ErrUnsupportedFormat):
+		v.AddError("cover", "must be a JPEG, PNG or WebP image")
+	case errors.Is(err, imaging.ErrTooManyPixels):
+		v.AddError("cover", fmt.Sprintf("must not have more than %d pixels", maxCoverPixels))
+	case err != nil:
+		v.AddError("cover", "must be a valid image")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	ext := imaging.Formats[contentType]
+
+	// Store each upload under a fresh random prefix, so that the new cover never
+	// overwrites the old one while it may still be cached.
+	token := make([]byte, 8)
+	_, err = rand.Read(token)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	prefix := fmt.Sprintf("books/%d/%s", book.ID, hex.EncodeToString(token))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	keys := []string{prefix + "/original." + ext}
+	err = app.storage.Put(ctx, keys[0], original, contentType)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	thumbnails := make(map[string]string)
+	for name, width := range coverThumbnails {
+		thumbnail, err := imaging.Thumbnail(img, width)
+		if err == nil {
+			key := prefix + "/" + name + ".jpg"
+			keys = append(keys, key)
+			err = app.storage.Put(ctx, key, thumbnail, "image/jpeg")
+			thumbnails[name] = app.storage.URL(key)
+		}
+		if err != nil {
+			app.removeCoverFiles(keys)
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	oldCoverURL := book.CoverURL
+	book.CoverURL = app.storage.URL(keys[0])
+
+	err = app.models.Books.UpdateCover(book, app.contextGetUser(r).ID)
+	if err != nil {
+		app.removeCoverFiles(keys)
+		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if oldCoverURL != "" {
+		app.removeCoverFiles(app.coverKeys(oldCoverURL))
+	}
+
+	cover := envelope{"url": book.CoverURL, "thumbnails": thumbnails}
+	w.Header().Set("ETag", bookETag(book))
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"book": book, "cover": cover}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The coverKeys() helper returns the storage keys of a cover and its thumbnails, given
+// the cover's URL. It returns nothing for covers which aren't in our storage.
+func (app *application) coverKeys(coverURL string) []string {
+	base := app.storage.URL("")
+	if !strings.HasPrefix(coverURL, base) {
+		return nil
+	}
+
+	original := strings.TrimPrefix(coverURL, base)
+	keys := []string{original}
+	for name := range coverThumbnails {
+		keys = append(keys, path.Dir(original)+"/"+name+".jpg")
+	}
+	return keys
+}
+
+// The removeCoverFiles() helper deletes the given files from storage in the background.
+// Failures are only logged, as the files are no longer referenced by any book.
+func (app *application) removeCoverFiles(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		for _, key := range keys {
+			err := app.storage.Delete(ctx, key)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"key": key})
+			}
+		}
+	})
+}
+
+// The serveCovers() helper returns a handler serving the files of a local storage. It
+// refuses to list directories.
+func (app *application) serveCovers(local *storage.Local) http.Handler {
+	files := http.StripPrefix("/v1/covers", http.FileServer(http.Dir(local.Dir())))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			app.notFoundResponse(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
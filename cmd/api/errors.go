@@ -1,27 +1,42 @@
 package main
 
 import (
+	"errors"
+	"finalProjectAdvancedP/internal/data"
 	"fmt"
 	"net/http"
 )
 
 // the logError() is a generic helper for logging an error message.
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
+	properties := map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
-	})
+	}
+	// Errors can be logged before the client IP address has been worked out, such as
+	// for a panic in the first middleware, so don't rely on it being there.
+	if ip, ok := r.Context().Value(clientIPContextKey).(string); ok {
+		properties["client_ip"] = ip
+	}
+	app.logger.PrintError(err, properties)
 }
 
 // the errorResponse() method is a generic helper for sending JSON-formatted error
-// to the client with a given status code.
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
+// to the client with a given status code. The code is a short, stable, name for the
+// kind of error, which clients can act on. It is only sent in problem details (see
+// problemResponse()), as the original format just has the message.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, message any) {
+	if app.wantsProblem(r) {
+		app.problemResponse(w, r, status, code, message)
+		return
+	}
+
 	env := envelope{"error": message}
 
 	// Write the response using the writeJSON() helper. If this happens to return an
 	// error then log it, and fall back to sending the client an empty response with a
 	// 500 Internal Server Error status code.
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeJSON(w, r, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
@@ -36,25 +51,25 @@ func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Reque
 	app.logError(r, err)
 
 	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, "server_error", message)
 }
 
 // The notFoundResponse() method will be used to send a 404 Not Found status code and
 // JSON response to the client.
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, "not_found", message)
 }
 
 // The methodNotAllowedResponse() method will be used to send a 405 Method Not Allowed
 // status code and JSON response to the client.
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, "method_not_allowed", message)
 }
 
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, "bad_request", err.Error())
 }
 
 // add a new failedValidationResponse() helper, which writes 422 Unprocessable Entity
@@ -62,33 +77,63 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 // Note that the errors parameter here has the type map[string]string, which is exactly
 // the same as the errors map contained in our Validator type.
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, "failed_validation", errors)
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, http.StatusConflict, "edit_conflict", message)
+}
+
+// The preconditionFailedResponse() method is sent when the record has changed since the
+// version named in the request's If-Match header.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been changed since the version given in If-Match, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, "precondition_failed", message)
 }
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, http.StatusTooManyRequests, "rate_limit_exceeded", message)
 }
 
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, "invalid_credentials", message)
 }
 
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 	message := "invalid or missing authentication token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, "invalid_authentication_token", message)
+}
+
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, "authentication_required", message)
+}
+
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can only be sent as application/json"
+	app.errorResponse(w, r, http.StatusNotAcceptable, "not_acceptable", message)
 }
 
 func (app *application) notAdminErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
 
 	message := "only admin can perform such function"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, "admin_required", message)
+}
+
+// The constraintViolationResponse() method sends the client the field-level message
+// for a database constraint violation. Unique violations mean the record clashes with
+// one that already exists, so they get a 409 Conflict; everything else is a problem
+// with the submitted data and gets a 422 Unprocessable Entity, just like a failed
+// validation.
+func (app *application) constraintViolationResponse(w http.ResponseWriter, r *http.Request, err *data.ConstraintError) {
+	status, code := http.StatusUnprocessableEntity, "failed_validation"
+	if errors.Is(err, data.ErrUniqueViolation) {
+		status, code = http.StatusConflict, "already_exists"
+	}
+	app.errorResponse(w, r, status, code, map[string]string{err.Field: err.Message})
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"finalProjectAdvancedP/internal/data"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The versionETag() helper returns the strong ETag of a record at the given version.
+// Every change to a record bumps its version, so the version identifies the record's
+// representation without us having to hash it.
+func versionETag(version int32) string {
+	return `"` + strconv.FormatInt(int64(version), 10) + `"`
+}
+
+// The bookETag() helper returns the strong ETag of a book. A book's representation
+// embeds its publisher and authors, which can change without the book's version being
+// bumped (a publisher being renamed, say), so the ETag is made of the version followed
+// by a hash of the book's JSON. The hash is taken of the compact JSON, so that the ETag
+// doesn't depend on ?pretty=true.
+func bookETag(book *data.Book) string {
+	js, err := json.Marshal(book)
+	if err != nil {
+		return versionETag(book.Version)
+	}
+	sum := sha256.Sum256(js)
+	return `"` + strconv.FormatInt(int64(book.Version), 10) + "-" + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// The etagList() helper splits the value of an If-Match or If-None-Match header into
+// its entity tags.
+func etagList(header string) []string {
+	var etags []string
+	for _, etag := range strings.Split(header, ",") {
+		if etag = strings.TrimSpace(etag); etag != "" {
+			etags = append(etags, etag)
+		}
+	}
+	return etags
+}
+
+// The weakMatch() helper reports whether the etag matches one of the tags in an
+// If-None-Match header, using the weak comparison: the W/ prefix is ignored.
+func weakMatch(header, etag string) bool {
+	for _, candidate := range etagList(header) {
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// The checkIfMatch() helper checks the If-Match header of a request which changes a
+// record, against the record's current ETag. It sends a 412 Precondition Failed
+// response and returns false if the header doesn't name the current ETag. Weak ETags
+// never match, as If-Match uses the strong comparison.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, candidate := range etagList(header) {
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	app.preconditionFailedResponse(w, r)
+	return false
+}
+
+// The notModified() helper sets the ETag header of a response for a record, and reports
+// whether the client's copy is still current according to its If-None-Match header. If
+// so, it sends a 304 Not Modified response and the handler shouldn't write anything else.
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if weakMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// The writeListJSON() helper works like writeJSON(), for list responses. A list doesn't
+// have a version of its own, so it is sent with a weak ETag hashed from the response
+// body, and with a Last-Modified header if lastModified isn't zero. Clients which still
+// have the same list get a 304 Not Modified response instead. As in RFC 9110,
+// If-Modified-Since is only looked at when there is no If-None-Match header.
+func (app *application) writeListJSON(w http.ResponseWriter, r *http.Request, data envelope, lastModified time.Time) error {
+	js, err := marshalJSON(r, data)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(js)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if weakMatch(ifNoneMatch, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	} else if !lastModified.IsZero() {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err == nil && !lastModified.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(js)
+	return nil
+}
+
+// The setCacheControl() helper lets clients and shared caches reuse a catalog response
+// for the time set by the -cache-max-age flag, after which they should revalidate it
+// with its ETag. Responses meant for admins only (listing deleted books, say) may only
+// be kept by the client itself.
+func (app *application) setCacheControl(w http.ResponseWriter, private bool) {
+	if app.config.cache.maxAge <= 0 {
+		w.Header().Set("Cache-Control", "no-cache")
+		return
+	}
+	scope := "public"
+	if private {
+		scope = "private"
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(app.config.cache.maxAge.Seconds())))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"finalProjectAdvancedP/internal/data"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWeakMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		want   bool
+	}{
+		{"", `"3"`, false},
+		{`"3"`, `"3"`, true},
+		{`"2", "3"`, `"3"`, true},
+		{`W/"3"`, `"3"`, true},
+		{`"3"`, `W/"3"`, true},
+		{`"4"`, `"3"`, false},
+		{"*", `"3"`, true},
+		{`"3-gzip"`, `"3"`, false},
+	}
+
+	for _, tt := range tests {
+		if got := weakMatch(tt.header, tt.etag); got != tt.want {
+			t.Errorf("weakMatch(%q, %q) = %v; want %v", tt.header, tt.etag, got, tt.want)
+		}
+	}
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	app := &application{}
+
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", true},
+		{`"7-abc"`, true},
+		{`"6-abc", "7-abc"`, true},
+		{"*", true},
+		{`W/"7-abc"`, false},
+		{`"7"`, false},
+		{`"6-abc"`, false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPatch, "/v1/books/1", nil)
+		if tt.header != "" {
+			r.Header.Set("If-Match", tt.header)
+		}
+		w := httptest.NewRecorder()
+
+		got := app.checkIfMatch(w, r, `"7-abc"`)
+		if got != tt.want {
+			t.Errorf("checkIfMatch(%q) = %v; want %v", tt.header, got, tt.want)
+		}
+		if !got && w.Code != http.StatusPreconditionFailed {
+			t.Errorf("checkIfMatch(%q) sent status %d; want %d", tt.header, w.Code, http.StatusPreconditionFailed)
+		}
+	}
+}
+
+// A book's ETag must change when its publisher or authors do, even though the book's
+// own version stays the same.
+func TestBookETag(t *testing.T) {
+	book := &data.Book{
+		ID:        1,
+		Title:     "Dune",
+		Authors:   []*data.Author{{ID: 1, Name: "Frank Herbert"}},
+		Publisher: &data.Publisher{ID: 1, Name: "Chilton Books"},
+		Version:   3,
+	}
+
+	etag := bookETag(book)
+	if etag != bookETag(book) {
+		t.Fatal("bookETag() isn't stable")
+	}
+
+	book.Publisher.Name = "Ace Books"
+	renamed := bookETag(book)
+	if renamed == etag {
+		t.Error("ETag didn't change with the publisher's name")
+	}
+
+	book.Authors[0].Bio = "American science fiction author."
+	if bookETag(book) == renamed {
+		t.Error("ETag didn't change with the author's bio")
+	}
+}
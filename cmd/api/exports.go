@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"finalProjectAdvancedP/internal/data"
+	"finalProjectAdvancedP/internal/validator"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFormats maps each export format to its content type and file extension.
+var exportFormats = map[string]struct{ contentType, ext string }{
+	"csv":    {"text/csv; charset=utf-8", "csv"},
+	"ndjson": {"application/x-ndjson", "ndjson"},
+	"onix":   {"application/xml; charset=utf-8", "xml"},
+}
+
+// A bookExporter writes books out in one of the export formats. Begin() is called before
+// the first book and End() after the last one.
+type bookExporter interface {
+	Begin() error
+	Write(book *data.Book) error
+	End() error
+}
+
+// The exportBooksHandler streams every book matching the same search parameters (and
+// sort) as listBooksHandler, as CSV, NDJSON or an ONIX 3.0 message. The CSV columns are
+// the ones accepted by importBooksHandler, so an export can be imported again.
+func (app *application) exportBooksHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.BookSearch
+		data.Filters
+		Format string
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.BookSearch = app.readBookSearch(qs, v)
+	input.Format = app.readString(qs, "format", "csv")
+	input.Filters.Page = 1
+	input.Filters.PageSize = 1
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "title", "year", "price", "relevance", "-id", "-title", "-year", "-price"}
+
+	_, ok := exportFormats[input.Format]
+	v.Check(ok, "format", "must be csv, ndjson or onix")
+	data.ValidateBookSearch(v, input.BookSearch)
+	data.ValidateFilters(v, input.Filters)
+	if input.Filters.Sort == "relevance" {
+		v.Check(input.BookSearch.Query != "", "sort", "relevance can only be used together with q")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	format := exportFormats[input.Format]
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="books-%s.%s"`, time.Now().UTC().Format("20060102"), format.ext))
+
+	// Buffer the output, and keep track of whether any of it has reached the client.
+	// Until it has, we can still send a proper error response if something fails.
+	cw := &countingWriter{w: w}
+	buf := bufio.NewWriterSize(cw, 32<<10)
+
+	var exporter bookExporter
+	switch input.Format {
+	case "csv":
+		exporter = &csvExporter{w: csv.NewWriter(buf)}
+	case "ndjson":
+		exporter = &ndjsonExporter{enc: json.NewEncoder(buf)}
+	case "onix":
+		exporter = &onixExporter{enc: xml.NewEncoder(buf), w: buf, sender: app.config.export.onixSender, currency: app.config.export.onixCurrency}
+	}
+
+	err := exporter.Begin()
+	if err == nil {
+		err = app.models.Books.Export(r.Context(), input.BookSearch, input.Filters, exporter.Write)
+	}
+	if err == nil {
+		err = exporter.End()
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		if cw.n == 0 {
+			w.Header().Del("Content-Disposition")
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// Part of the export has already been sent, so all we can do is log the error
+		// and cut the response short. Client disconnects aren't worth logging.
+		if r.Context().Err() == nil && err != context.Canceled {
+			app.logError(r, err)
+		}
+	}
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// csvExportColumns holds the columns of CSV exports, all of which are understood by
+// imports. Authors are exported as a list of names rather than as the author display
+// string, so that a book with several authors is imported again with the same authors.
+var csvExportColumns = []string{"title", "authors", "year", "genres", "price", "isbn10", "isbn13"}
+
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvExporter) Begin() error {
+	return e.w.Write(csvExportColumns)
+}
+
+func (e *csvExporter) Write(book *data.Book) error {
+	authors := []string{book.Author}
+	if len(book.Authors) > 0 {
+		authors = make([]string, len(book.Authors))
+		for i, author := range book.Authors {
+			authors[i] = author.Name
+		}
+	}
+
+	return e.w.Write([]string{
+		book.Title,
+		strings.Join(authors, "|"),
+		strconv.FormatInt(int64(book.Year), 10),
+		strings.Join(book.Genres, "|"),
+		strconv.FormatUint(book.Price, 10),
+		book.ISBN10,
+		book.ISBN13,
+	})
+}
+
+func (e *csvExporter) End() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonExporter struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonExporter) Begin() error { return nil }
+
+// The json.Encoder ends each value with a newline, which is exactly what NDJSON needs.
+func (e *ndjsonExporter) Write(book *data.Book) error {
+	return e.enc.Encode(book)
+}
+
+func (e *ndjsonExporter) End() error { return nil }
+
+// The onixExporter writes an ONIX for Books 3.0 message (reference tags), with one
+// Product record per book. See https://www.editeur.org/93/Release-3.0-Downloads/.
+type onixExporter struct {
+	enc      *xml.Encoder
+	w        io.Writer
+	sender   string
+	currency string
+}
+
+// The types below model the parts of an ONIX 3.0 Product record that we fill in. The
+// order of the fields matters, as ONIX is strict about the order of elements.
+type onixProduct struct {
+	XMLName            xml.Name                `xml:"Product"`
+	RecordReference    string                  `xml:"RecordReference"`
+	NotificationType   string                  `xml:"NotificationType"`
+	ProductIdentifiers []onixProductIdentifier `xml:"ProductIdentifier"`
+	DescriptiveDetail  onixDescriptiveDetail   `xml:"DescriptiveDetail"`
+	PublishingDetail   onixPublishingDetail    `xml:"PublishingDetail"`
+	ProductSupply      onixProductSupply       `xml:"ProductSupply"`
+}
+
+type onixProductIdentifier struct {
+	ProductIDType string `xml:"ProductIDType"`
+	IDValue       string `xml:"IDValue"`
+}
+
+type onixDescriptiveDetail struct {
+	ProductComposition string            `xml:"ProductComposition"`
+	ProductForm        string            `xml:"ProductForm"`
+	TitleDetail        onixTitleDetail   `xml:"TitleDetail"`
+	Contributors       []onixContributor `xml:"Contributor"`
+	Subjects           []onixSubject     `xml:"Subject"`
+}
+
+type onixTitleDetail struct {
+	TitleType    string `xml:"TitleType"`
+	TitleElement struct {
+		TitleElementLevel string `xml:"TitleElementLevel"`
+		TitleText         string `xml:"TitleText"`
+	} `xml:"TitleElement"`
+}
+
+type onixContributor struct {
+	SequenceNumber  int    `xml:"SequenceNumber"`
+	ContributorRole string `xml:"ContributorRole"`
+	PersonName      string `xml:"PersonName"`
+}
+
+type onixSubject struct {
+	SubjectSchemeIdentifier string `xml:"SubjectSchemeIdentifier"`
+	SubjectHeadingText      string `xml:"SubjectHeadingText"`
+}
+
+type onixPublishingDetail struct {
+	Publisher      *onixPublisher `xml:"Publisher,omitempty"`
+	PublishingDate struct {
+		PublishingDateRole string `xml:"PublishingDateRole"`
+		Date               struct {
+			Format string `xml:"dateformat,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"Date"`
+	} `xml:"PublishingDate"`
+}
+
+type onixPublisher struct {
+	PublishingRole string `xml:"PublishingRole"`
+	PublisherName  string `xml:"PublisherName"`
+}
+
+type onixProductSupply struct {
+	SupplyDetail struct {
+		Supplier struct {
+			SupplierRole string `xml:"SupplierRole"`
+			SupplierName string `xml:"SupplierName"`
+		} `xml:"Supplier"`
+		ProductAvailability string `xml:"ProductAvailability"`
+		Price               struct {
+			PriceType    string `xml:"PriceType"`
+			PriceAmount  string `xml:"PriceAmount"`
+			CurrencyCode string `xml:"CurrencyCode"`
+		} `xml:"Price"`
+	} `xml:"SupplyDetail"`
+}
+
+func (e *onixExporter) Begin() error {
+	_, err := io.WriteString(e.w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	message := xml.StartElement{
+		Name: xml.Name{Local: "ONIXMessage"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "release"}, Value: "3.0"},
+			{Name: xml.Name{Local: "xmlns"}, Value: "http://ns.editeur.org/onix/3.0/reference"},
+		},
+	}
+	err = e.enc.EncodeToken(message)
+	if err != nil {
+		return err
+	}
+
+	header := struct {
+		XMLName xml.Name `xml:"Header"`
+		Sender  struct {
+			SenderName string `xml:"SenderName"`
+		} `xml:"Sender"`
+		SentDateTime string `xml:"SentDateTime"`
+	}{}
+	header.Sender.SenderName = e.sender
+	header.SentDateTime = time.Now().UTC().Format("20060102T1504Z")
+	return e.enc.Encode(header)
+}
+
+func (e *onixExporter) Write(book *data.Book) error {
+	p := onixProduct{
+		RecordReference:  fmt.Sprintf("%s.book.%d", strings.ToLower(strings.ReplaceAll(e.sender, " ", "")), book.ID),
+		NotificationType: "03", // notification confirmed on publication
+		ProductIdentifiers: []onixProductIdentifier{
+			{ProductIDType: "01", IDValue: strconv.FormatInt(book.ID, 10)}, // proprietary
+		},
+	}
+	if book.ISBN10 != "" {
+		p.ProductIdentifiers = append(p.ProductIdentifiers, onixProductIdentifier{ProductIDType: "02", IDValue: book.ISBN10})
+	}
+	if book.ISBN13 != "" {
+		p.ProductIdentifiers = append(p.ProductIdentifiers, onixProductIdentifier{ProductIDType: "15", IDValue: book.ISBN13})
+	}
+
+	d := &p.DescriptiveDetail
+	d.ProductComposition = "00" // single-component retail product
+	d.ProductForm = "BA"        // book
+	d.TitleDetail.TitleType = "01"
+	d.TitleDetail.TitleElement.TitleElementLevel = "01"
+	d.TitleDetail.TitleElement.TitleText = book.Title
+	for i, author := range book.Authors {
+		d.Contributors = append(d.Contributors, onixContributor{SequenceNumber: i + 1, ContributorRole: "A01", PersonName: author.Name})
+	}
+	if len(book.Authors) == 0 {
+		d.Contributors = append(d.Contributors, onixContributor{SequenceNumber: 1, ContributorRole: "A01", PersonName: book.Author})
+	}
+	for _, genre := range book.Genres {
+		d.Subjects = append(d.Subjects, onixSubject{SubjectSchemeIdentifier: "20", SubjectHeadingText: genre}) // keywords
+	}
+
+	pd := &p.PublishingDetail
+	if book.Publisher != nil {
+		pd.Publisher = &onixPublisher{PublishingRole: "01", PublisherName: book.Publisher.Name}
+	}
+	pd.PublishingDate.PublishingDateRole = "01" // publication date
+	pd.PublishingDate.Date.Format = "05"        // YYYY
+	pd.PublishingDate.Date.Value = strconv.FormatInt(int64(book.Year), 10)
+
+	s := &p.ProductSupply.SupplyDetail
+	s.Supplier.SupplierRole = "00"
+	s.Supplier.SupplierName = e.sender
+	s.ProductAvailability = "20" // available
+	s.Price.PriceType = "01"     // recommended retail price, excluding tax
+	s.Price.PriceAmount = strconv.FormatUint(book.Price, 10)
+	s.Price.CurrencyCode = e.currency
+
+	return e.enc.Encode(p)
+}
+
+func (e *onixExporter) End() error {
+	err := e.enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: "ONIXMessage"}})
+	if err != nil {
+		return err
+	}
+	return e.enc.Flush()
+}
@@ -37,14 +37,11 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 // http.ResponseWriter, the HTTP status code to send, the data to encode to JSON, and a
 // header map containing any additional HTTP headers we want to include in the response.
 // UPD: use data envelope instead of data any
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
 	// use json.Marshal() function to encode our map into JSON format ( a []byte slice)
-	// also do not forget to check for errors
-
-	// Use the json.MarshalIndent() function so that whitespace is added to the encoded
-	// JSON. Here we use no line prefix ("") and tab indents ("\t") for each element.
-	// note that json.MarshalIndent() takes more memory and increases runtime
-	js, err := json.MarshalIndent(data, "", "\t")
+	// also do not forget to check for errors. The JSON is compact, to save bandwidth,
+	// unless the client asks for it to be indented for humans with ?pretty=true.
+	js, err := marshalJSON(r, data)
 	if err != nil {
 		return err
 	}
@@ -62,6 +59,15 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	return nil
 }
 
+// The marshalJSON() helper encodes data for a response. The pretty query string
+// parameter has the JSON indented with tabs, which is easier to read but larger.
+func marshalJSON(r *http.Request, data any) ([]byte, error) {
+	if pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty")); pretty {
+		return json.MarshalIndent(data, "", "\t")
+	}
+	return json.Marshal(data)
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
 	// Use http.MaxBytesReader() to limit the size of the request body to 1MB.
 	maxBytes := 1_048_576
@@ -200,6 +206,40 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// The readFloat() helper reads a string value from the query string and converts it to
+// a float before returning. If no matching key could be found it returns the provided
+// default value. If the value couldn't be converted to a float, then we record an error
+// message in the provided Validator instance.
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		v.AddError(key, "must be a number")
+		return defaultValue
+	}
+	return f
+}
+
+// The readBool() helper reads a string value from the query string and converts it to a
+// boolean before returning. If no matching key could be found it returns the provided
+// default value. If the value couldn't be converted to a boolean, then we record an
+// error message in the provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
 // The background() helper accepts an arbitrary function as a parameter.
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"finalProjectAdvancedP/internal/data"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxIdempotencyKeyLength = 255
+	// idempotencyLockTimeout is how long a request with an Idempotency-Key may take
+	// before a retry with the same key is allowed to take over from it.
+	idempotencyLockTimeout = time.Minute
+)
+
+// The idempotent() middleware makes a write endpoint safe to retry. The first response
+// to a request with an Idempotency-Key header is stored, and retries of the request
+// with the same key (by the same user, to the same method and path) get that response
+// again without the handler being run. Anonymous requests are told apart by
+// idempotencyClient(). Reusing a key for a different request body is a
+// client error, and a retry which arrives while the first request is still being
+// processed is turned away with a 409 Conflict. Requests without the header are passed
+// through as they are.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keyValue := r.Header.Get("Idempotency-Key")
+		if keyValue == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(keyValue) > maxIdempotencyKeyLength {
+			app.badRequestResponse(w, r, fmt.Errorf("Idempotency-Key must not be more than %d bytes long", maxIdempotencyKeyLength))
+			return
+		}
+
+		// Read the body so that we can tell whether a retry is the same request, and put
+		// it back for the handler. readJSON() accepts bodies of up to 1MB.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxBytesError):
+				app.errorResponse(w, r, http.StatusRequestEntityTooLarge, "request_too_large", "body must not be larger than 1048576 bytes")
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		io.WriteString(hash, r.URL.RawQuery+"\n")
+		hash.Write(body)
+
+		user := app.contextGetUser(r)
+		key := &data.IdempotencyKey{
+			UserID:      user.ID,
+			Key:         keyValue,
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			RequestHash: hash.Sum(nil),
+		}
+		if user.IsAnonymous() {
+			key.Client = app.idempotencyClient(r, body)
+		}
+
+		stored, err := app.models.Idempotency.Begin(key, app.config.idempotency.ttl, idempotencyLockTimeout)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if stored != nil {
+			switch {
+			case stored.RequestHash != nil && !bytes.Equal(stored.RequestHash, key.RequestHash):
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency-Key has already been used for a different request")
+			case stored.Status == 0:
+				w.Header().Set("Retry-After", "1")
+				app.errorResponse(w, r, http.StatusConflict, "idempotency_key_in_use", "a request with this Idempotency-Key is still being processed")
+			default:
+				// Headers already set by the middleware in front of this one (such as
+				// the CORS and rate limit headers) are about this request, so they are
+				// kept rather than replayed.
+				for name, values := range stored.Header {
+					if _, ok := w.Header()[name]; !ok {
+						w.Header()[name] = values
+					}
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.Status)
+				w.Write(stored.Body)
+			}
+			return
+		}
+
+		// We hold the key now. If the handler panics, give the key up again so that the
+		// request can be retried, and leave the panic to recoverPanic().
+		completed := false
+		defer func() {
+			if !completed {
+				err := app.models.Idempotency.Release(key)
+				if err != nil {
+					app.logError(r, err)
+				}
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// Server errors aren't stored, as the request may well succeed if it is retried.
+		if rec.status >= 500 {
+			return
+		}
+
+		key.Status = rec.status
+		key.Header = rec.Header().Clone()
+		// The body is stored as the handler wrote it, before any compression, so the
+		// headers describing the encoding must not be replayed with it.
+		delete(key.Header, "Content-Encoding")
+		delete(key.Header, "Content-Length")
+		key.Body = rec.body.Bytes()
+		err = app.models.Idempotency.Complete(key)
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+		completed = true
+	}
+}
+
+// The idempotencyClient() helper returns what an anonymous request's Idempotency-Key
+// is scoped by, so that two clients which happen to use the same key don't get each
+// other's responses. The cart and checkout endpoints name the customer by the email in
+// the request body, which stays the same when a mobile client retries from another
+// network, so that is used if there is one. Otherwise the key is scoped by the client's
+// IP address.
+func (app *application) idempotencyClient(r *http.Request, body []byte) string {
+	var input struct {
+		Email string `json:"email"`
+	}
+	if json.Unmarshal(body, &input) == nil && strings.TrimSpace(input.Email) != "" {
+		return "email:" + strings.ToLower(strings.TrimSpace(input.Email))
+	}
+	return "ip:" + app.contextGetClientIP(r)
+}
+
+// A responseRecorder passes a response through to the client, while keeping a copy of
+// its status code and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// The purgeIdempotencyKeys() method deletes the expired idempotency keys once an hour.
+// It runs for the lifetime of the application.
+func (app *application) purgeIdempotencyKeys() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := app.models.Idempotency.DeleteExpired()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+		if deleted > 0 {
+			app.logger.PrintInfo("deleted expired idempotency keys", map[string]string{"keys": strconv.FormatInt(deleted, 10)})
+		}
+	}
+}
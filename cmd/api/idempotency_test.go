@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIdempotencyClient(t *testing.T) {
+	app := &application{}
+
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"email":"Alice@Example.com","book_id":1,"quantity":2}`, "email:alice@example.com"},
+		{`{"email":" bob@example.com "}`, "email:bob@example.com"},
+		{`{"email":""}`, "ip:203.0.113.7"},
+		{`{"book_id":1}`, "ip:203.0.113.7"},
+		{`not json`, "ip:203.0.113.7"},
+		{``, "ip:203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/v1/cart", nil)
+		r = app.contextSetClientIP(r, "203.0.113.7")
+		if got := app.idempotencyClient(r, []byte(tt.body)); got != tt.want {
+			t.Errorf("idempotencyClient(%q) = %q; want %q", tt.body, got, tt.want)
+		}
+	}
+}
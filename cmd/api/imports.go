@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"finalProjectAdvancedP/internal/data"
+	"finalProjectAdvancedP/internal/validator"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxImportBytes = 32 << 20 // largest import file accepted
+	maxImportRows  = 50_000   // most rows accepted in one import
+)
+
+// importColumns holds the columns understood in CSV imports. A header row naming the
+// columns is required, but the columns may come in any order and only title, author (or
+// authors), year, genres and price are required. The author column holds the name of a
+// single author, and the authors column the names of one or more authors; when both are
+// given, authors wins. Authors and genres are separated by "|".
+var importColumns = []string{"title", "author", "authors", "year", "genres", "price", "isbn10", "isbn13"}
+
+// An importRecord is a book as read from an import file, before it is validated.
+type importRecord struct {
+	Title   string   `json:"title"`
+	Author  string   `json:"author"`
+	Authors []string `json:"authors"`
+	Year    int32    `json:"year"`
+	Genres  []string `json:"genres"`
+	Price   uint64   `json:"price"`
+	ISBN10  string   `json:"isbn10"`
+	ISBN13  string   `json:"isbn13"`
+}
+
+// The importBooksHandler accepts a CSV or NDJSON (newline-delimited JSON) file of books
+// in the request body, and merges it into the catalog. The format is taken from the
+// format query string parameter, or else the Content-Type header. In the default
+// "atomic" mode nothing is imported unless every row is valid, while in "best_effort"
+// mode the valid rows are imported and the others are reported as rejected.
+func (app *application) importBooksHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	format := app.readString(qs, "format", "")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "text/csv":
+			format = "csv"
+		case "application/x-ndjson", "application/jsonl", "application/json-lines":
+			format = "ndjson"
+		}
+	}
+	mode := app.readString(qs, "mode", "atomic")
+
+	v.Check(validator.PermittedValue(format, "csv", "ndjson"), "format", "must be csv or ndjson (or set by a text/csv or application/x-ndjson Content-Type)")
+	v.Check(validator.PermittedValue(mode, "atomic", "best_effort"), "mode", "must be atomic or best_effort")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	var records []importRecord
+	var results []data.ImportResult
+	var err error
+	switch format {
+	case "csv":
+		records, results, err = readCSVImport(r.Body)
+	case "ndjson":
+		records, results, err = readNDJSONImport(r.Body)
+	}
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, "request_too_large", fmt.Sprintf("import must not be larger than %d bytes", maxImportBytes))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Validate each row as if it were created through createBookHandler. Rows which
+	// would end up as the same book as an earlier row are rejected too, as they would
+	// otherwise silently overwrite it.
+	rows := []data.ImportRow{}
+	seen := make(map[string]int)
+	for i, record := range records {
+		if results[i].Status == data.ImportRejected {
+			continue
+		}
+
+		book := &data.Book{
+			Title:  record.Title,
+			Author: record.Author,
+			Year:   record.Year,
+			Genres: record.Genres,
+			Price:  record.Price,
+			ISBN10: validator.NormalizeISBN(record.ISBN10),
+			ISBN13: validator.NormalizeISBN(record.ISBN13),
+		}
+		// A list of authors makes up the display string in the same way as for a book
+		// created with author_ids, so that matching by title and author still works.
+		if len(record.Authors) > 0 {
+			for _, name := range record.Authors {
+				book.Authors = append(book.Authors, &data.Author{Name: strings.TrimSpace(name)})
+			}
+			book.Author = strings.Join(record.Authors, ", ")
+		}
+
+		rv := validator.New()
+		validateImportAuthors(rv, record.Authors)
+		data.ValidateBook(rv, book, taxonomy)
+		// ValidateBook() reports too many authors under author_ids, as that's how the
+		// API takes them, but an import lists them under authors.
+		if message, ok := rv.Errors["author_ids"]; ok {
+			delete(rv.Errors, "author_ids")
+			rv.Check(false, "authors", message)
+		}
+		if !rv.Valid() {
+			results[i] = data.ImportResult{Row: results[i].Row, Status: data.ImportRejected, Errors: rv.Errors}
+			continue
+		}
+
+		keys := []string{"title:" + strings.ToLower(book.Title) + "\x00" + strings.ToLower(book.Author)}
+		if isbn13 := importISBN13(book); isbn13 != "" {
+			keys = append(keys, "isbn:"+isbn13)
+		}
+		duplicate := false
+		for _, key := range keys {
+			if row, ok := seen[key]; ok {
+				results[i] = data.ImportResult{Row: results[i].Row, Status: data.ImportRejected, Errors: map[string]string{"row": fmt.Sprintf("is the same book as row %d", row)}}
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+		for _, key := range keys {
+			seen[key] = results[i].Row
+		}
+
+		rows = append(rows, data.ImportRow{Row: results[i].Row, Book: book})
+	}
+
+	// In atomic mode, a single invalid row means that nothing is imported.
+	rejected := len(records) - len(rows)
+	if mode == "atomic" && rejected > 0 {
+		app.writeImportReport(w, r, http.StatusUnprocessableEntity, results)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 25*time.Second)
+	defer cancel()
+
+	imported, err := app.models.Books.Import(ctx, rows, mode == "atomic", app.contextGetUser(r).ID)
+	if err != nil {
+		var constraintErr *data.ConstraintError
+		switch {
+		case errors.As(err, &constraintErr):
+			app.constraintViolationResponse(w, r, constraintErr)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Fill the outcome of the imported rows in alongside the rejected ones.
+	index := make(map[int]int, len(results))
+	for i, result := range results {
+		index[result.Row] = i
+	}
+	for _, result := range imported {
+		results[index[result.Row]] = result
+	}
+
+	app.writeImportReport(w, r, http.StatusOK, results)
+}
+
+// The writeImportReport() helper sends the outcome of each row of an import, along with
+// the number of rows with each outcome.
+func (app *application) writeImportReport(w http.ResponseWriter, r *http.Request, status int, results []data.ImportResult) {
+	counts := map[string]int{data.ImportCreated: 0, data.ImportUpdated: 0, data.ImportRejected: 0}
+	for _, result := range results {
+		counts[result.Status]++
+	}
+
+	report := envelope{
+		"created":  counts[data.ImportCreated],
+		"updated":  counts[data.ImportUpdated],
+		"rejected": counts[data.ImportRejected],
+		"rows":     results,
+	}
+	err := app.writeJSON(w, r, status, envelope{"import": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The validateImportAuthors() helper checks the names in an import row's list of
+// authors. Authors are matched by name regardless of case, so the same name can't be
+// listed twice.
+func validateImportAuthors(v *validator.Validator, names []string) {
+	lower := make([]string, len(names))
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		v.Check(name != "", "authors", "must not contain empty names")
+		v.Check(len(name) <= 100, "authors", "must not contain names more than 100 bytes long")
+		lower[i] = strings.ToLower(name)
+	}
+	v.Check(validator.Unique(lower), "authors", "must not contain duplicate values")
+}
+
+// The importISBN13() helper returns the ISBN-13 a book will be matched by, deriving it
+// from the ISBN-10 if need be.
+func importISBN13(book *data.Book) string {
+	if book.ISBN13 != "" {
+		return book.ISBN13
+	}
+	isbn13, _ := validator.ISBN10To13(book.ISBN10)
+	return isbn13
+}
+
+// The readCSVImport() function reads a CSV import file. It returns one record and one
+// result per data row; rows which can't be parsed are returned as an empty record with
+// a rejected result. An error is only returned if the file as a whole can't be read.
+func readCSVImport(body io.Reader) ([]importRecord, []data.ImportResult, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil, errors.New("body must not be empty")
+		}
+		return nil, nil, err
+	}
+
+	// Every row must have as many fields as the header.
+	reader.FieldsPerRecord = len(header)
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !validator.PermittedValue(name, importColumns...) {
+			return nil, nil, fmt.Errorf("header contains unknown column %q", name)
+		}
+		columns[name] = i
+	}
+	for _, name := range []string{"title", "year", "genres", "price"} {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("header must contain a %q column", name)
+		}
+	}
+	_, hasAuthor := columns["author"]
+	_, hasAuthors := columns["authors"]
+	if !hasAuthor && !hasAuthors {
+		return nil, nil, errors.New(`header must contain an "author" or "authors" column`)
+	}
+
+	var records []importRecord
+	var results []data.ImportResult
+	for row := 1; ; row++ {
+		fields, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if row > maxImportRows {
+			return nil, nil, fmt.Errorf("import must not contain more than %d rows", maxImportRows)
+		}
+
+		var record importRecord
+		errs := map[string]string{}
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr):
+			errs["row"] = parseErr.Err.Error()
+		case err != nil:
+			return nil, nil, err
+		default:
+			field := func(name string) string {
+				if i, ok := columns[name]; ok {
+					return strings.TrimSpace(fields[i])
+				}
+				return ""
+			}
+
+			record.Title = field("title")
+			record.Author = field("author")
+			record.Authors = splitList(field("authors"))
+			record.ISBN10 = field("isbn10")
+			record.ISBN13 = field("isbn13")
+			record.Genres = splitList(field("genres"))
+
+			year, err := strconv.ParseInt(field("year"), 10, 32)
+			if err != nil {
+				errs["year"] = "must be an integer"
+			}
+			record.Year = int32(year)
+			price, err := strconv.ParseUint(field("price"), 10, 64)
+			if err != nil {
+				errs["price"] = "must be a positive integer"
+			}
+			record.Price = price
+		}
+
+		result := data.ImportResult{Row: row}
+		if len(errs) > 0 {
+			record = importRecord{}
+			result.Status = data.ImportRejected
+			result.Errors = errs
+		}
+		records = append(records, record)
+		results = append(results, result)
+	}
+
+	return records, results, nil
+}
+
+// The splitList() helper splits a "|"-separated CSV field into its trimmed, non-empty
+// items. It returns nil for an empty field.
+func splitList(field string) []string {
+	var items []string
+	for _, item := range strings.Split(field, "|") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// The readNDJSONImport() function reads an NDJSON import file, with one JSON object per
+// line using the same keys as the book JSON, except that authors (if given) is a list of
+// names. Blank lines are skipped, but still count towards the row numbers so that they
+// match the line numbers.
+func readNDJSONImport(body io.Reader) ([]importRecord, []data.ImportResult, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+
+	var records []importRecord
+	var results []data.ImportResult
+	for row := 1; scanner.Scan(); row++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if len(records) >= maxImportRows {
+			return nil, nil, fmt.Errorf("import must not contain more than %d rows", maxImportRows)
+		}
+
+		var record importRecord
+		result := data.ImportResult{Row: row}
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&record)
+		if err == nil && dec.More() {
+			err = errors.New("must contain a single JSON object")
+		}
+		if err != nil {
+			record = importRecord{}
+			result.Status = data.ImportRejected
+			result.Errors = map[string]string{"row": err.Error()}
+		}
+
+		records = append(records, record)
+		results = append(results, result)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, errors.New("body must not be empty")
+	}
+
+	return records, results, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"finalProjectAdvancedP/internal/data"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCSVImport(t *testing.T) {
+	body := "\ufeffTitle,author,year,genres,price,isbn13\n" +
+		"Dune,Frank Herbert,1965,science-fiction|classics,1999,9780441172719\n" +
+		"Bad Year,Someone,soon,fantasy,500,\n" +
+		"\"Broken,quote\",x\n" +
+		" Emma , Jane Austen ,1815, romance ,899,\n"
+
+	records, results, err := readCSVImport(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantRecords := []importRecord{
+		{Title: "Dune", Author: "Frank Herbert", Year: 1965, Genres: []string{"science-fiction", "classics"}, Price: 1999, ISBN13: "9780441172719"},
+		{},
+		{},
+		{Title: "Emma", Author: "Jane Austen", Year: 1815, Genres: []string{"romance"}, Price: 899},
+	}
+	if !reflect.DeepEqual(records, wantRecords) {
+		t.Errorf("records = %+v; want %+v", records, wantRecords)
+	}
+
+	wantResults := []data.ImportResult{
+		{Row: 1},
+		{Row: 2, Status: data.ImportRejected, Errors: map[string]string{"year": "must be an integer"}},
+		{Row: 3, Status: data.ImportRejected, Errors: map[string]string{"row": "wrong number of fields"}},
+		{Row: 4},
+	}
+	if !reflect.DeepEqual(results, wantResults) {
+		t.Errorf("results = %+v; want %+v", results, wantResults)
+	}
+}
+
+func TestReadCSVImportHeaderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"empty", "", "body must not be empty"},
+		{"unknown column", "title,author,year,genres,price,colour\n", `header contains unknown column "colour"`},
+		{"missing column", "title,author,year,genres\n", `header must contain a "price" column`},
+		{"missing author", "title,year,genres,price\n", `header must contain an "author" or "authors" column`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readCSVImport(strings.NewReader(tt.body))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("error = %v; want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// A book with several authors must come back from an export with the same authors.
+func TestCSVExportRoundTrip(t *testing.T) {
+	book := &data.Book{
+		Title:   "Good Omens",
+		Author:  "Terry Pratchett, Neil Gaiman",
+		Authors: []*data.Author{{Name: "Terry Pratchett"}, {Name: "Neil Gaiman"}},
+		Year:    1990,
+		Genres:  []string{"fantasy", "comedy"},
+		Price:   1250,
+		ISBN13:  "9780060853983",
+	}
+
+	var buf bytes.Buffer
+	exporter := &csvExporter{w: csv.NewWriter(&buf)}
+	for _, err := range []error{exporter.Begin(), exporter.Write(book), exporter.End()} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, results, err := readCSVImport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || results[0].Status != "" {
+		t.Fatalf("records = %+v, results = %+v; want one good record", records, results)
+	}
+
+	want := importRecord{
+		Title:   "Good Omens",
+		Authors: []string{"Terry Pratchett", "Neil Gaiman"},
+		Year:    1990,
+		Genres:  []string{"fantasy", "comedy"},
+		Price:   1250,
+		ISBN13:  "9780060853983",
+	}
+	if !reflect.DeepEqual(records[0], want) {
+		t.Errorf("record = %+v; want %+v", records[0], want)
+	}
+}
+
+func TestReadNDJSONImport(t *testing.T) {
+	body := `{"title":"Dune","author":"Frank Herbert","year":1965,"genres":["science-fiction"],"price":1999}
+
+{"title":"Emma","colour":"blue"}
+{"title":"A"} {"title":"B"}
+not json
+`
+
+	records, results, err := readNDJSONImport(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantRecords := []importRecord{
+		{Title: "Dune", Author: "Frank Herbert", Year: 1965, Genres: []string{"science-fiction"}, Price: 1999},
+		{},
+		{},
+		{},
+	}
+	if !reflect.DeepEqual(records, wantRecords) {
+		t.Errorf("records = %+v; want %+v", records, wantRecords)
+	}
+
+	// Blank lines are skipped, but the rows are still numbered by line.
+	wantRows := []int{1, 3, 4, 5}
+	wantStatuses := []string{"", data.ImportRejected, data.ImportRejected, data.ImportRejected}
+	if len(results) != len(wantRows) {
+		t.Fatalf("got %d results; want %d", len(results), len(wantRows))
+	}
+	for i, result := range results {
+		if result.Row != wantRows[i] || result.Status != wantStatuses[i] {
+			t.Errorf("result %d = row %d %q; want row %d %q", i, result.Row, result.Status, wantRows[i], wantStatuses[i])
+		}
+	}
+	if got := results[2].Errors["row"]; got != "must contain a single JSON object" {
+		t.Errorf("error for two objects on a line = %q", got)
+	}
+
+	_, _, err = readNDJSONImport(strings.NewReader("\n\n"))
+	if err == nil {
+		t.Error("expected an error for an empty body")
+	}
+}
@@ -2,12 +2,23 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"errors"
+	"expvar"
+	"finalProjectAdvancedP/internal/cache"
 	"finalProjectAdvancedP/internal/data"
 	"finalProjectAdvancedP/internal/jsonlog"
 	"finalProjectAdvancedP/internal/mailer"
+	"finalProjectAdvancedP/internal/ratelimit"
+	"finalProjectAdvancedP/internal/storage"
 	"flag"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"net"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -20,7 +31,68 @@ type config struct {
 	port int
 	env  string
 	db   struct {
-		dsn string // database source name
+		dsn                    string        // database source name
+		statementCacheCapacity int           // number of prepared statements cached per connection
+		replicaDSNs            []string      // optional read replicas for catalog queries
+		replicaHealthInterval  time.Duration // how often the read replicas are health checked
+	}
+	cursor struct {
+		secret []byte // key used to sign pagination cursors
+	}
+	search struct {
+		similarity        float64 // minimum trigram similarity for fuzzy catalog searches
+		suggestSimilarity float64 // minimum trigram similarity for autocomplete suggestions
+	}
+	storage struct {
+		backend string // "local" or "s3"
+		dir     string // directory for the local backend
+		baseURL string // address the local backend's files are served at
+		s3      storage.S3Config
+	}
+	covers struct {
+		maxBytes int64 // largest cover image accepted for upload
+	}
+	books struct {
+		retention     time.Duration // how long deleted books are kept before being purged
+		purgeInterval time.Duration // how often deleted books are purged
+	}
+	idempotency struct {
+		ttl time.Duration // how long the response to a request with an Idempotency-Key is kept
+	}
+	tls  tlsSettings
+	cors struct {
+		trustedOrigins   []string // origins whose browser scripts may call the API
+		allowCredentials bool     // whether those scripts may make requests with credentials, such as cookies
+	}
+	compression struct {
+		minSize int // smallest response body which is compressed, in bytes
+	}
+	problems struct {
+		enabled     bool   // send every error as RFC 7807 problem details, not just to clients which ask
+		typeBaseURL string // base URL of the documentation for each error code, used for the problem type
+	}
+	proxies struct {
+		trusted []*net.IPNet // proxies whose forwarding header is believed
+		header  string       // "x-forwarded-for" or "forwarded": the header the proxies write
+	}
+	limiter struct {
+		enabled  bool
+		store    string           // "memory" or "redis"
+		redisURL string           // server used by the redis store
+		auth     ratelimit.Policy // signing up, activating and logging in
+		read     ratelimit.Policy // GET and other safe requests
+		write    ratelimit.Policy // every other request
+	}
+	cache struct {
+		backend  string        // "memory", "redis" or "none"
+		size     int           // most entries kept by the memory backend
+		redisURL string        // server used by the redis backend
+		ttl      time.Duration // how long catalog query results are cached
+		maxAge   time.Duration // how long clients may cache catalog responses
+	}
+	export struct {
+		onixSender   string // sender and supplier name in ONIX exports
+		onixCurrency string // ISO 4217 currency code of book prices in ONIX exports
 	}
 	smtp struct {
 		host     string
@@ -31,14 +103,29 @@ type config struct {
 	}
 }
 
+// The tlsSettings type holds the settings for serving HTTPS.
+type tlsSettings struct {
+	certFile     string        // certificate (chain) file; HTTPS is served when this is set
+	keyFile      string        // private key file
+	redirectPort int           // port of a plain HTTP listener which redirects to HTTPS (0 for none)
+	hstsMaxAge   time.Duration // max-age of the Strict-Transport-Security header (0 to leave it out)
+}
+
+// The enabled() method reports whether HTTPS is served.
+func (s tlsSettings) enabled() bool {
+	return s.certFile != ""
+}
+
 // application struct
 // needs to be done
 type application struct {
-	models data.Models
-	config config
-	mailer mailer.Mailer
-	logger *jsonlog.Logger
-	wg     sync.WaitGroup
+	models  data.Models
+	config  config
+	mailer  mailer.Mailer
+	storage storage.Storage
+	limiter ratelimit.Store
+	logger  *jsonlog.Logger
+	wg      sync.WaitGroup
 }
 
 // starting point of our application
@@ -48,7 +135,84 @@ func main() {
 
 	flag.IntVar(&cfg.port, "port", 8000, "API server port")
 	flag.StringVar(&cfg.env, "environment", "development", "Environment (development)")
+	flag.StringVar(&cfg.tls.certFile, "tls-cert", "", "TLS certificate file; serves HTTPS when set")
+	flag.StringVar(&cfg.tls.keyFile, "tls-key", "", "TLS private key file")
+	flag.IntVar(&cfg.tls.redirectPort, "tls-redirect-port", 0, "Port to redirect plain HTTP requests to HTTPS from (0 disables)")
+	flag.DurationVar(&cfg.tls.hstsMaxAge, "hsts-max-age", 2*365*24*time.Hour, "max-age of the Strict-Transport-Security header sent over HTTPS (0 disables)")
+
+	flag.IntVar(&cfg.compression.minSize, "compression-min-size", 1024, "Smallest response body, in bytes, compressed for clients which accept gzip or brotli")
+	flag.BoolVar(&cfg.problems.enabled, "problem-details", false, "Send all errors as RFC 7807 problem details (clients can also ask for them with Accept: application/problem+json)")
+	flag.StringVar(&cfg.problems.typeBaseURL, "problem-type-base-url", "", "Base URL of the error code documentation, used as the problem type (default about:blank)")
+
+	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
+		cfg.cors.trustedOrigins = strings.Fields(val)
+		return nil
+	})
+	flag.BoolVar(&cfg.cors.allowCredentials, "cors-allow-credentials", false, "Let trusted CORS origins send credentials")
+	flag.Func("trusted-proxies", "CIDR ranges of the proxies in front of the server, whose forwarding header is trusted (comma separated)", func(val string) error {
+		proxies, err := parseTrustedProxies(val)
+		if err != nil {
+			return err
+		}
+		cfg.proxies.trusted = proxies
+		return nil
+	})
+	flag.StringVar(&cfg.proxies.header, "trusted-proxy-header", "x-forwarded-for", "Header the trusted proxies add the client address to (x-forwarded-for|forwarded); the other one is ignored")
 	flag.StringVar(&cfg.db.dsn, "db-dsn", DATABASE_URL, "PostgreSQL dsn")
+	flag.IntVar(&cfg.db.statementCacheCapacity, "db-statement-cache-capacity", 512, "PostgreSQL prepared statement cache capacity per connection")
+	// Read replica DSNs are given as a single comma-separated list, which we split into
+	// the cfg.db.replicaDSNs slice.
+	flag.Func("db-replica-dsns", "PostgreSQL read replica DSNs (comma separated)", func(val string) error {
+		for _, dsn := range strings.Split(val, ",") {
+			if dsn = strings.TrimSpace(dsn); dsn != "" {
+				cfg.db.replicaDSNs = append(cfg.db.replicaDSNs, dsn)
+			}
+		}
+		return nil
+	})
+	flag.DurationVar(&cfg.db.replicaHealthInterval, "db-replica-health-interval", 10*time.Second, "PostgreSQL read replica health check interval")
+
+	flag.Func("cursor-secret", "Secret key used to sign pagination cursors", func(val string) error {
+		cfg.cursor.secret = []byte(val)
+		return nil
+	})
+
+	flag.Float64Var(&cfg.search.similarity, "search-similarity", 0.4, "Minimum trigram similarity for fuzzy book searches (0-1)")
+	flag.Float64Var(&cfg.search.suggestSimilarity, "search-suggest-similarity", 0.3, "Minimum trigram similarity for book suggestions (0-1)")
+
+	flag.StringVar(&cfg.storage.backend, "storage-backend", "local", "Storage backend for uploaded files (local|s3)")
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "./uploads", "Directory for uploaded files (local backend)")
+	flag.StringVar(&cfg.storage.baseURL, "storage-base-url", "", "Public base URL of uploaded files (local backend, default http://localhost:<port>/v1/covers)")
+	flag.StringVar(&cfg.storage.s3.Endpoint, "s3-endpoint", "", "S3-compatible endpoint, e.g. https://s3.eu-central-1.amazonaws.com or http://localhost:9000")
+	flag.StringVar(&cfg.storage.s3.Bucket, "s3-bucket", "", "S3 bucket for uploaded files")
+	flag.StringVar(&cfg.storage.s3.Region, "s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&cfg.storage.s3.AccessKey, "s3-access-key", "", "S3 access key")
+	flag.StringVar(&cfg.storage.s3.SecretKey, "s3-secret-key", "", "S3 secret key")
+	flag.StringVar(&cfg.storage.s3.PublicURL, "s3-public-url", "", "Public base URL of the S3 bucket (default <endpoint>/<bucket>)")
+	flag.Int64Var(&cfg.covers.maxBytes, "cover-max-bytes", 5<<20, "Maximum size of an uploaded cover image in bytes")
+
+	flag.DurationVar(&cfg.books.retention, "books-retention", 30*24*time.Hour, "How long deleted books can be restored before they are purged")
+	flag.DurationVar(&cfg.books.purgeInterval, "books-purge-interval", time.Hour, "How often deleted books past their retention are purged (0 disables purging)")
+
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "How long responses to requests with an Idempotency-Key header are kept for replay")
+
+	// Each rate limit policy allows a number of requests per period for each IP address
+	// and, optionally, a higher number for each authenticated user.
+	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiting")
+	flag.StringVar(&cfg.limiter.store, "limiter-store", "memory", "Where request counts are kept (memory|redis)")
+	flag.StringVar(&cfg.limiter.redisURL, "limiter-redis-url", "redis://localhost:6379/0", "Redis server URL (redis store)")
+	rateLimitFlag(&cfg.limiter.auth, "limiter-auth", "auth", "10/1m", "Rate limit for signing up, activating and logging in")
+	rateLimitFlag(&cfg.limiter.read, "limiter-read", "read", "120,600/1m", "Rate limit for reads, per IP address and per user")
+	rateLimitFlag(&cfg.limiter.write, "limiter-write", "write", "60,300/1m", "Rate limit for writes, per IP address and per user")
+
+	flag.StringVar(&cfg.cache.backend, "cache-backend", "memory", "Cache for catalog queries (memory|redis|none)")
+	flag.IntVar(&cfg.cache.size, "cache-size", 10_000, "Maximum number of cached catalog query results (memory backend)")
+	flag.StringVar(&cfg.cache.redisURL, "cache-redis-url", "redis://localhost:6379/0", "Redis server URL (redis backend)")
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", 5*time.Minute, "How long catalog query results are cached")
+	flag.DurationVar(&cfg.cache.maxAge, "cache-max-age", time.Minute, "max-age sent in the Cache-Control header of catalog responses (0 disables client caching)")
+
+	flag.StringVar(&cfg.export.onixSender, "onix-sender", "Bookstore", "Sender name used in ONIX catalog exports")
+	flag.StringVar(&cfg.export.onixCurrency, "onix-currency", "USD", "ISO 4217 currency code of book prices in ONIX catalog exports")
 
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.office365.com", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 587, "SMTP port")
@@ -62,10 +226,34 @@ func main() {
 
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	if cfg.tls.enabled() != (cfg.tls.keyFile != "") {
+		logger.PrintFatal(errors.New("-tls-cert and -tls-key must be given together"), nil)
+	}
+	for name, similarity := range map[string]float64{"-search-similarity": cfg.search.similarity, "-search-suggest-similarity": cfg.search.suggestSimilarity} {
+		if similarity < 0 || similarity > 1 {
+			logger.PrintFatal(fmt.Errorf("%s must be between 0 and 1", name), nil)
+		}
+	}
+	cfg.proxies.header = strings.ToLower(cfg.proxies.header)
+	if cfg.proxies.header != "x-forwarded-for" && cfg.proxies.header != "forwarded" {
+		logger.PrintFatal(fmt.Errorf("unknown -trusted-proxy-header %q", cfg.proxies.header), nil)
+	}
+
+	// If no cursor secret was given, generate a random one. Cursors then only remain
+	// valid until the server restarts, and aren't shared between instances.
+	if len(cfg.cursor.secret) == 0 {
+		cfg.cursor.secret = make([]byte, 32)
+		_, err := rand.Read(cfg.cursor.secret)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		logger.PrintInfo("no cursor secret provided, using a random one", nil)
+	}
+
 	// Call the openDB() helper function (see below) to create the connection pool,
 	// passing in the config struct. If this returns an error, we log it and exit the
 	// application immediately.
-	db, err := openDB(cfg)
+	db, err := openDB(cfg, cfg.db.dsn)
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
@@ -74,32 +262,89 @@ func main() {
 	defer db.Close()
 	logger.PrintInfo("database connection pool established", nil)
 
+	// Open a separate connection pool for each read replica. These are only used for
+	// read-only catalog queries, so the primary stays responsible for all writes. The
+	// primary can serve those queries too, so a replica which is down doesn't stop the
+	// application from starting: it is taken out of rotation until it recovers.
+	var replicaDBs []*sql.DB
+	for i, dsn := range cfg.db.replicaDSNs {
+		replicaDB, err := newDB(cfg, dsn)
+		if err != nil {
+			logger.PrintFatal(err, map[string]string{"replica": fmt.Sprint(i)})
+		}
+		replicaDBs = append(replicaDBs, replicaDB)
+	}
+	replicas := data.NewReplicas(db, replicaDBs...)
+	defer replicas.Close()
+	if len(replicaDBs) > 0 {
+		for i, err := range replicas.CheckHealth() {
+			if err != nil {
+				logger.PrintError(err, map[string]string{"replica": fmt.Sprint(i)})
+			}
+		}
+		logger.PrintInfo("read replica connection pools established", map[string]string{
+			"replicas": fmt.Sprint(len(replicaDBs)),
+		})
+	}
+
+	// Keep an eye on the replicas in the background, so that one which goes away is
+	// taken out of rotation and put back once it recovers.
+	go replicas.MonitorHealth(cfg.db.replicaHealthInterval)
+
+	store, err := openStorage(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	logger.PrintInfo("file storage configured", map[string]string{"backend": cfg.storage.backend})
+
+	limiter, err := openLimiter(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	bookCache, err := openCache(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	logger.PrintInfo("catalog cache configured", map[string]string{"backend": cfg.cache.backend})
+	// Publish the cache's hit and miss counts, which can be read at /debug/vars.
+	expvar.Publish("book_cache", expvar.Func(func() any {
+		return bookCache.Stats()
+	}))
+
 	// declare an instance of our application
 	// Use the data.NewModels() function to initialize a Models struct, passing in the
 	// connection pool as a parameter.
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		config:  cfg,
+		logger:  logger,
+		models:  data.NewModels(db, replicas, bookCache),
+		storage: store,
+		limiter: limiter,
 		// Initialize a new Mailer instance using the settings from the command line
 		// flags, and add it to the application struct.
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
 	}
 
+	// Purge the deleted books whose retention has run out in the background.
+	go app.purgeDeletedBooks()
+	// Likewise for the expired idempotency keys.
+	go app.purgeIdempotencyKeys()
+
 	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
 }
 
-// The openDB() function returns a sql.DB connection pool.
-func openDB(cfg config) (*sql.DB, error) {
-	// Use sql.Open() to create an empty connection pool, using the DSN from the config
-	// struct.
-	db, err := sql.Open("postgres", cfg.db.dsn)
+// The openDB() function returns a sql.DB connection pool for the given DSN, once it has
+// checked that the database can be reached.
+func openDB(cfg config, dsn string) (*sql.DB, error) {
+	db, err := newDB(cfg, dsn)
 	if err != nil {
 		return nil, err
 	}
+
 	// Create a context with a 5-second timeout deadline.
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -110,8 +355,103 @@ func openDB(cfg config) (*sql.DB, error) {
 	// error.
 	err = db.PingContext(ctx)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 	// Return the sql.DB connection pool.
 	return db, nil
 }
+
+// The newDB() function returns a sql.DB connection pool for the given DSN, without
+// connecting to the database. It is used for both the primary database and the read
+// replicas.
+func newDB(cfg config, dsn string) (*sql.DB, error) {
+	// Parse the DSN into a pgx connection config.
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Have pgx prepare each query the first time it is run on a connection and keep the
+	// prepared statement in a per-connection LRU cache. This means that hot queries like
+	// BookModel.Get(), BookModel.GetAll() and UserModel.GetForToken() are only parsed and
+	// planned by PostgreSQL once per connection, rather than on every call.
+	connConfig.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
+	connConfig.StatementCacheCapacity = cfg.db.statementCacheCapacity
+
+	// Use stdlib.OpenDB() to create an empty connection pool which uses the pgx driver
+	// with the settings above.
+	return stdlib.OpenDB(*connConfig), nil
+}
+
+// The openStorage() function returns the storage for uploaded files selected by the
+// -storage-backend flag.
+func openStorage(cfg config) (storage.Storage, error) {
+	switch cfg.storage.backend {
+	case "local":
+		baseURL := cfg.storage.baseURL
+		if baseURL == "" {
+			baseURL = fmt.Sprintf("http://localhost:%d/v1/covers", cfg.port)
+		}
+		return storage.NewLocal(cfg.storage.dir, baseURL)
+	case "s3":
+		return storage.NewS3(cfg.storage.s3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.storage.backend)
+	}
+}
+
+// The openCache() function returns the cache for catalog queries selected by the
+// -cache-backend flag, which is nil if caching is turned off.
+func openCache(cfg config) (*data.BookCache, error) {
+	switch cfg.cache.backend {
+	case "memory":
+		return data.NewBookCache(cache.NewLRU(cfg.cache.size), cfg.cache.ttl), nil
+	case "redis":
+		redis, err := cache.NewRedis(cfg.cache.redisURL, "bookstore:", 16)
+		if err != nil {
+			return nil, err
+		}
+		return data.NewBookCache(redis, cfg.cache.ttl), nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.cache.backend)
+	}
+}
+
+// The rateLimitFlag() function defines a flag holding a rate limit policy, in the form
+// read by ratelimit.ParsePolicy(), and sets the policy to its default value.
+func rateLimitFlag(p *ratelimit.Policy, flagName, policyName, value, usage string) {
+	policy, err := ratelimit.ParsePolicy(policyName, value)
+	if err != nil {
+		panic(err)
+	}
+	*p = policy
+
+	flag.Func(flagName, fmt.Sprintf("%s (default %q)", usage, value), func(val string) error {
+		policy, err := ratelimit.ParsePolicy(policyName, val)
+		if err != nil {
+			return err
+		}
+		*p = policy
+		return nil
+	})
+}
+
+// The openLimiter() function returns the store for rate limit counts selected by the
+// -limiter-store flag.
+func openLimiter(cfg config) (ratelimit.Store, error) {
+	switch cfg.limiter.store {
+	case "memory":
+		return ratelimit.NewMemory(), nil
+	case "redis":
+		client, err := cache.NewRedis(cfg.limiter.redisURL, "", 16)
+		if err != nil {
+			return nil, err
+		}
+		return ratelimit.NewRedis(client, "bookstore:ratelimit:"), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limiter store %q", cfg.limiter.store)
+	}
+}
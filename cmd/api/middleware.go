@@ -3,13 +3,12 @@ package main
 import (
 	"errors"
 	"finalProjectAdvancedP/internal/data"
+	"finalProjectAdvancedP/internal/ratelimit"
 	"finalProjectAdvancedP/internal/validator"
 	"fmt"
-	"golang.org/x/time/rate"
-	"net"
 	"net/http"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -37,61 +36,110 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	// Define a client struct to hold the rate limiter and last seen time for each
-	// client.
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
-	var (
-		mu sync.Mutex
-		// Update the map so the values are pointers to a client struct.
-		clients = make(map[string]*client)
-	)
-	// Launch a background goroutine which removes old entries from the clients map once
-	// every minute.
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-			// Lock the mutex to prevent any rate limiter checks from happening while
-			// the cleanup is taking place.
-			mu.Lock()
-			// Loop through all clients. If they haven't been seen within the last three
-			// minutes, delete the corresponding entry from the map.
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
-			// Importantly, unlock the mutex when the cleanup is complete.
-			mu.Unlock()
-		}
-	}()
+
+// The rateLimitIP() middleware limits how many requests each client may make, under
+// the policy for the group of routes the request is for, counting them by IP address.
+// It runs before authenticate(), so that requests with a bad or unknown token, and the
+// token lookups they cause, are limited like any other. Requests which carry a token
+// are counted separately from anonymous ones, with the user quota, so that users aren't
+// held to the anonymous limit; once authenticated, rateLimitUser() applies their own
+// quota too. The counts are kept in app.limiter, which may be shared by several
+// instances of the application.
+func (app *application) rateLimitIP(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Extract the client's IP address from the request.
-		ip, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
+		if !app.config.limiter.enabled {
+			next.ServeHTTP(w, r)
 			return
 		}
-		mu.Lock()
-		if _, found := clients[ip]; !found {
-			// Create and add a new client struct to the map if it doesn't already exist.
-			clients[ip] = &client{limiter: rate.NewLimiter(2, 4)}
+
+		policy := app.rateLimitPolicy(r)
+		key := policy.Name + ":ip:" + app.contextGetClientIP(r)
+		quota := policy.Anonymous
+		if r.Header.Get("Authorization") != "" && policy.User.Limit > 0 {
+			key = policy.Name + ":token-ip:" + app.contextGetClientIP(r)
+			quota = policy.User
+		}
+
+		if app.takeRateLimit(w, r, key, quota) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// The rateLimitUser() middleware limits authenticated users by user id, with the user
+// quota of the request's policy, so that users behind the same IP address don't use up
+// each other's requests. It must run after authenticate(). Anonymous requests, and
+// policies without a user quota, have already been limited by rateLimitIP().
+func (app *application) rateLimitUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enabled {
+			next.ServeHTTP(w, r)
+			return
 		}
-		// Update the last seen time for the client.
-		clients[ip].lastSeen = time.Now()
-		if !clients[ip].limiter.Allow() {
-			mu.Unlock()
-			app.rateLimitExceededResponse(w, r)
+
+		policy := app.rateLimitPolicy(r)
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() || policy.User.Limit == 0 {
+			next.ServeHTTP(w, r)
 			return
 		}
-		mu.Unlock()
-		next.ServeHTTP(w, r)
+
+		key := policy.Name + ":user:" + strconv.FormatInt(user.ID, 10)
+		if app.takeRateLimit(w, r, key, policy.User) {
+			next.ServeHTTP(w, r)
+		}
 	})
 }
 
+// The takeRateLimit() helper counts a request against key. Every response tells the
+// client where it stands, in the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers. A client which is over the limit is sent a 429 Too Many
+// Requests response, which tells it when to come back in Retry-After, and the helper
+// returns false.
+func (app *application) takeRateLimit(w http.ResponseWriter, r *http.Request, key string, quota ratelimit.Quota) bool {
+	count, reset, err := app.limiter.Take(r.Context(), key, quota.Period)
+	if err != nil {
+		// If the store can't be reached, let the request through rather than turn
+		// every client away.
+		app.logError(r, err)
+		return true
+	}
+
+	remaining := quota.Limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	// Round the reset time up, so that a client which waits for it finds a new window.
+	resetSeconds := strconv.FormatInt(int64((reset+time.Second-1)/time.Second), 10)
+	w.Header().Set("RateLimit-Limit", strconv.FormatInt(quota.Limit, 10))
+	w.Header().Set("RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+	w.Header().Set("RateLimit-Reset", resetSeconds)
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", quota.Limit, int64(quota.Period/time.Second)))
+
+	if count > quota.Limit {
+		w.Header().Set("Retry-After", resetSeconds)
+		app.rateLimitExceededResponse(w, r)
+		return false
+	}
+	return true
+}
+
+// The rateLimitPolicy() method returns the rate limit policy for the request's route
+// group. Signing up, activating an account and logging in have a tight limit of their
+// own, to slow down password guessing; other requests are limited as reads or writes.
+func (app *application) rateLimitPolicy(r *http.Request) ratelimit.Policy {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/tokens/authentication",
+		r.Method == http.MethodPost && r.URL.Path == "/v1/users",
+		r.Method == http.MethodPut && r.URL.Path == "/v1/users/activated":
+		return app.config.limiter.auth
+	case r.Method == http.MethodGet, r.Method == http.MethodHead, r.Method == http.MethodOptions:
+		return app.config.limiter.read
+	default:
+		return app.config.limiter.write
+	}
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any
@@ -156,3 +204,131 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// The requireAdminUser() middleware only lets requests from authenticated admin users
+// through to the next handler.
+func (app *application) requireAdminUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+		if !user.Admin {
+			app.notAdminErrorResponse(w, r, fmt.Errorf("user %d attempted an admin-only request", user.ID))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// The request headers which browsers may send to the API from other origins, beyond the
+// ones they can always send, and the response headers which scripts on those origins
+// may read.
+const (
+	corsAllowedMethods = "OPTIONS, GET, POST, PUT, PATCH, DELETE"
+	corsAllowedHeaders = "Authorization, Content-Type, Idempotency-Key, If-Match, If-None-Match, If-Modified-Since, X-Expected-Version"
+	corsExposedHeaders = "ETag, Last-Modified, Content-Disposition, Idempotent-Replayed, Retry-After, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, RateLimit-Policy"
+)
+
+// The enableCORS() middleware lets browser scripts on the origins listed in
+// -cors-trusted-origins call the API. Preflight requests from those origins are
+// answered here, without going any further. Requests from other origins are passed
+// through as they are, without any CORS headers, so the browser doesn't let the script
+// see the response.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response depends on the Origin header (and for preflight requests, on
+		// the requested method), so caches must keep the responses apart.
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || !app.isTrustedOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if app.config.cors.allowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		// A preflight request is an OPTIONS request with an
+		// Access-Control-Request-Method header.
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The isTrustedOrigin() method reports whether origin is one of the trusted origins.
+func (app *application) isTrustedOrigin(origin string) bool {
+	for _, trusted := range app.config.cors.trustedOrigins {
+		if origin == trusted {
+			return true
+		}
+	}
+	return false
+}
+
+// The requireAcceptableType() middleware turns away requests whose Accept header doesn't
+// allow a JSON response, with a 406 Not Acceptable. No Accept header means anything
+// goes. Book exports and cover images aren't JSON, so they are let through.
+func (app *application) requireAcceptableType(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exempt := r.URL.Path == "/v1/books/export" || strings.HasPrefix(r.URL.Path, "/v1/covers/")
+		if !exempt && !acceptsJSON(r.Header.Get("Accept")) {
+			app.notAcceptableResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The acceptsJSON() function reports whether an Accept header allows application/json.
+// Clients opt into problem details for errors with Accept: application/problem+json
+// (see wantsProblem()), so that counts as accepting JSON too, even on its own.
+func acceptsJSON(header string) bool {
+	if strings.TrimSpace(header) == "" {
+		return true
+	}
+	for _, part := range strings.Split(header, ",") {
+		mediaRange, params, _ := strings.Cut(part, ";")
+		switch strings.ToLower(strings.TrimSpace(mediaRange)) {
+		case "application/json", "application/problem+json", "application/*", "*/*":
+			if qValue(params) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// The secureHeaders() middleware adds security headers to every response. The API only
+// serves JSON (and cover images), so the Content-Security-Policy doesn't allow a browser
+// to load or run anything from it, nor to show it in a frame. Over HTTPS, the
+// Strict-Transport-Security header tells browsers to only ever use HTTPS with us.
+func (app *application) secureHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'; base-uri 'none'; form-action 'none'")
+
+		if app.config.tls.enabled() && app.config.tls.hstsMaxAge > 0 {
+			w.Header().Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", int64(app.config.tls.hstsMaxAge/time.Second)))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
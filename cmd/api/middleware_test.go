@@ -0,0 +1,145 @@
+package main
+
+import (
+	"finalProjectAdvancedP/internal/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Requests with a bad token must be rate limited before the token is looked up, by IP
+// address, and without eating into the anonymous clients' quota.
+func TestRateLimitBadTokens(t *testing.T) {
+	app := &application{limiter: ratelimit.NewMemory()}
+	app.config.limiter.enabled = true
+	app.config.limiter.read = ratelimit.Policy{
+		Name:      "read",
+		Anonymous: ratelimit.Quota{Limit: 2, Period: time.Minute},
+		User:      ratelimit.Quota{Limit: 3, Period: time.Minute},
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := app.rateLimitIP(app.authenticate(app.rateLimitUser(ok)))
+
+	get := func(ip, authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v1/books", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		r = app.contextSetClientIP(r, ip)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i, want := range []int{401, 401, 401, 429} {
+		w := get("203.0.113.7", "Bearer not-a-token")
+		if w.Code != want {
+			t.Errorf("bad token request %d: status %d; want %d", i+1, w.Code, want)
+		}
+		if w.Header().Get("RateLimit-Limit") != "3" {
+			t.Errorf("bad token request %d: RateLimit-Limit %q; want 3", i+1, w.Header().Get("RateLimit-Limit"))
+		}
+	}
+	if w := get("203.0.113.7", "Bearer not-a-token"); w.Header().Get("Retry-After") == "" {
+		t.Error("limited request has no Retry-After header")
+	}
+
+	// Anonymous requests from the same address have a quota of their own.
+	for i, want := range []int{200, 200, 429} {
+		if w := get("203.0.113.7", ""); w.Code != want {
+			t.Errorf("anonymous request %d: status %d; want %d", i+1, w.Code, want)
+		}
+	}
+
+	// Other addresses aren't affected.
+	if w := get("198.51.100.1", "Bearer not-a-token"); w.Code != http.StatusUnauthorized {
+		t.Errorf("bad token from another address: status %d; want 401", w.Code)
+	}
+}
+
+func TestRateLimitPolicy(t *testing.T) {
+	app := &application{}
+	app.config.limiter.auth = ratelimit.Policy{Name: "auth"}
+	app.config.limiter.read = ratelimit.Policy{Name: "read"}
+	app.config.limiter.write = ratelimit.Policy{Name: "write"}
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{http.MethodPost, "/v1/tokens/authentication", "auth"},
+		{http.MethodPost, "/v1/users", "auth"},
+		{http.MethodPut, "/v1/users/activated", "auth"},
+		{http.MethodGet, "/v1/books", "read"},
+		{http.MethodHead, "/v1/books/1", "read"},
+		{http.MethodOptions, "/v1/books", "read"},
+		{http.MethodPost, "/v1/books", "write"},
+		{http.MethodDelete, "/v1/books/1", "write"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := app.rateLimitPolicy(r).Name; got != tt.want {
+			t.Errorf("rateLimitPolicy(%s %s) = %s; want %s", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestAcceptsJSON(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", true},
+		{"application/json", true},
+		{"application/problem+json", true},
+		{"APPLICATION/PROBLEM+JSON", true},
+		{"application/*", true},
+		{"*/*", true},
+		{"text/html, */*;q=0.1", true},
+		{"text/html", false},
+		{"application/xml", false},
+		{"application/json;q=0", false},
+		{"application/problem+json;q=0", false},
+	}
+
+	for _, tt := range tests {
+		if got := acceptsJSON(tt.header); got != tt.want {
+			t.Errorf("acceptsJSON(%q) = %v; want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+// Clients which only accept problem details must get through to the handler, and have
+// its errors sent to them as problem details.
+func TestRequireAcceptableTypeProblemJSON(t *testing.T) {
+	app := &application{}
+	handler := app.requireAcceptableType(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.notFoundResponse(w, r)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/books/404", nil)
+	r.Header.Set("Accept", "application/problem+json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status %d; want %d", w.Code, http.StatusNotFound)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("Content-Type %q; want application/problem+json", got)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/v1/books/404", nil)
+	r.Header.Set("Accept", "text/html")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNotAcceptable {
+		t.Errorf("status for text/html %d; want %d", w.Code, http.StatusNotAcceptable)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// A problem is an error response in the RFC 7807 problem details format. Code is an
+// extension member holding the same stable error code that's used to build Type.
+type problem struct {
+	Type     string         `json:"type"`
+	Title    string         `json:"title"`
+	Status   int            `json:"status"`
+	Detail   string         `json:"detail,omitempty"`
+	Instance string         `json:"instance,omitempty"`
+	Code     string         `json:"code"`
+	Errors   []problemField `json:"errors,omitempty"`
+}
+
+// A problemField is the error for one field of a request which failed validation.
+type problemField struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// The wantsProblem() method reports whether an error response to the request should be
+// sent as problem details: either because -problem-details is set, or because the
+// client asked for application/problem+json in its Accept header.
+func (app *application) wantsProblem(r *http.Request) bool {
+	if app.config.problems.enabled {
+		return true
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaRange, params, _ := strings.Cut(part, ";")
+		if strings.EqualFold(strings.TrimSpace(mediaRange), "application/problem+json") && qValue(params) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// The problemResponse() method sends an error as problem details. The message is either
+// a string, which becomes the detail, or a map of field errors (as from a Validator),
+// which becomes the errors array, sorted by field so that the order is stable.
+func (app *application) problemResponse(w http.ResponseWriter, r *http.Request, status int, code string, message any) {
+	p := problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Instance: r.URL.Path,
+		Code:     code,
+	}
+	// Without a base URL for the types there is nothing for the type to point at, and
+	// RFC 7807 says to use about:blank, with the HTTP status text as the title.
+	if app.config.problems.typeBaseURL != "" {
+		p.Type = strings.TrimSuffix(app.config.problems.typeBaseURL, "/") + "/" + code
+	}
+
+	switch message := message.(type) {
+	case string:
+		p.Detail = message
+	case map[string]string:
+		p.Detail = "the request contains invalid fields"
+		for field, detail := range message {
+			p.Errors = append(p.Errors, problemField{Field: field, Detail: detail})
+		}
+		sort.Slice(p.Errors, func(i, j int) bool {
+			return p.Errors[i].Field < p.Errors[j].Field
+		})
+	}
+
+	js, err := marshalJSON(r, p)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// The parseTrustedProxies() function reads a comma-separated list of CIDR ranges, such
+// as "10.0.0.0/8,fd00::/8". Single IP addresses are accepted too.
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			ip := net.ParseIP(field)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: field}
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return proxies, nil
+}
+
+// The resolveClientIP() middleware works out the IP address of the client once per
+// request, and adds it to the request context for rate limiting and logging. Requests
+// which come straight from the client are taken at face value. When a request comes
+// from one of our trusted proxies (such as the load balancer), we look at the
+// addresses the proxies have added to the header set by the -trusted-proxy-header flag:
+// X-Forwarded-For or Forwarded. Only that header is read. Proxies pass the other one
+// on from the client untouched, so a client could put any address it liked there.
+// Going from the nearest hop backwards, the first address which isn't a trusted proxy
+// is the client; anything further back was sent by the client itself, and can't be
+// relied on.
+func (app *application) resolveClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = app.contextSetClientIP(r, app.clientIP(r))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The clientIP() method returns the client IP address of the request, as described for
+// resolveClientIP().
+func (app *application) clientIP(r *http.Request) string {
+	ip := hostIP(r.RemoteAddr)
+	if ip == nil {
+		return r.RemoteAddr
+	}
+	if !app.isTrustedProxy(ip) {
+		return ip.String()
+	}
+
+	var hops []string
+	switch app.config.proxies.header {
+	case "forwarded":
+		hops = forwardedFor(r.Header.Values("Forwarded"))
+	default:
+		hops = xForwardedFor(r.Header.Values("X-Forwarded-For"))
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hostIP(hops[i])
+		// An address we can't read (such as "unknown", or an obfuscated identifier) is
+		// as far as we can trace the request back, so the last proxy is the best we can
+		// do.
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !app.isTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+// The isTrustedProxy() method reports whether ip is in one of the trusted proxy ranges.
+func (app *application) isTrustedProxy(ip net.IP) bool {
+	for _, proxy := range app.config.proxies.trusted {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// The hostIP() function parses an IP address which may have a port attached, as in
+// "192.0.2.1:4711" or "[2001:db8::1]:4711". It returns nil if there is no valid
+// address.
+func hostIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+}
+
+// The xForwardedFor() function returns the addresses listed in X-Forwarded-For
+// headers, in order. Several headers count as one list, as in RFC 9110.
+func xForwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// The forwardedFor() function returns the "for" addresses in Forwarded headers (RFC
+// 7239), in order. An element without a "for" parameter is returned as "", so that it
+// still counts as a hop.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				name, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "for") {
+					hop = strings.Trim(val, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		header        string
+		remoteAddr    string
+		xForwardedFor string
+		forwarded     string
+		want          string
+	}{
+		{"direct client", "x-forwarded-for", "203.0.113.7:5000", "198.51.100.1", "", "203.0.113.7"},
+		{"one proxy", "x-forwarded-for", "10.0.0.2:5000", "203.0.113.7", "", "203.0.113.7"},
+		{"chain of proxies", "x-forwarded-for", "10.0.0.2:5000", "203.0.113.7, 192.0.2.1", "", "203.0.113.7"},
+		{"spoofed hops are ignored", "x-forwarded-for", "10.0.0.2:5000", "1.2.3.4, 203.0.113.7", "", "203.0.113.7"},
+		{"forwarded is ignored", "x-forwarded-for", "10.0.0.2:5000", "203.0.113.7", "for=1.2.3.4", "203.0.113.7"},
+		{"forwarded alone is ignored", "x-forwarded-for", "10.0.0.2:5000", "", "for=1.2.3.4", "10.0.0.2"},
+		{"unreadable hop", "x-forwarded-for", "10.0.0.2:5000", "unknown", "", "10.0.0.2"},
+		{"forwarded", "forwarded", "10.0.0.2:5000", "", `for="[2001:db8::1]:4711"`, "2001:db8::1"},
+		{"x-forwarded-for is ignored", "forwarded", "10.0.0.2:5000", "1.2.3.4", "for=203.0.113.7;proto=https", "203.0.113.7"},
+		{"no header", "forwarded", "10.0.0.2:5000", "", "", "10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{}
+			app.config.proxies.trusted = proxies
+			app.config.proxies.header = tt.header
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/books", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.xForwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.xForwardedFor)
+			}
+			if tt.forwarded != "" {
+				r.Header.Set("Forwarded", tt.forwarded)
+			}
+
+			if got := app.clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   []string
+	}{
+		{nil, nil},
+		{[]string{"for=192.0.2.60;proto=http;by=203.0.113.43"}, []string{"192.0.2.60"}},
+		{[]string{`For="[2001:db8:cafe::17]:4711"`}, []string{"[2001:db8:cafe::17]:4711"}},
+		{[]string{"for=192.0.2.43, for=198.51.100.17"}, []string{"192.0.2.43", "198.51.100.17"}},
+		{[]string{"for=192.0.2.43", "proto=https"}, []string{"192.0.2.43", ""}},
+		{[]string{"for=_hidden"}, []string{"_hidden"}},
+	}
+
+	for _, tt := range tests {
+		if got := forwardedFor(tt.values); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("forwardedFor(%q) = %q; want %q", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestXForwardedFor(t *testing.T) {
+	got := xForwardedFor([]string{"203.0.113.7, 10.0.0.1", " 10.0.0.2 "})
+	want := []string{"203.0.113.7", "10.0.0.1", "10.0.0.2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("xForwardedFor() = %q; want %q", got, want)
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1,, fd00::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, proxy := range proxies {
+		got = append(got, proxy.String())
+	}
+	want := []string{"10.0.0.0/8", "192.0.2.1/32", "fd00::1/128"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTrustedProxies() = %q; want %q", got, want)
+	}
+
+	for _, s := range []string{"10.0.0.0/33", "not-an-ip"} {
+		if _, err := parseTrustedProxies(s); err == nil {
+			t.Errorf("parseTrustedProxies(%q) succeeded; want an error", s)
+		}
+	}
+}
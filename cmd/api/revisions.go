@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"finalProjectAdvancedP/internal/data"
+	"finalProjectAdvancedP/internal/validator"
+	"net/http"
+)
+
+// The listBookHistoryHandler() returns a page of the book's revision history, newest
+// first. The history of deleted and purged books can still be looked up.
+func (app *application) listBookHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = "-id"
+	input.Filters.SortSafelist = []string{"-id"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions, metadata, err := app.models.Revisions.GetAll(id, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Every book has at least one revision, so a book without any doesn't exist.
+	if len(revisions) == 0 && input.Filters.Page == 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	lastModified, err := app.models.Revisions.LastModified(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeListJSON(w, r, envelope{"revisions": revisions, "metadata": metadata}, lastModified)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The revertBookHandler() sets a book back to the state it was in at one of its earlier
+// revisions. The revert is itself added to the history as a new revision, so it can be
+// undone in turn. The cover image isn't reverted, as the files of replaced covers are
+// removed.
+func (app *application) revertBookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		RevisionID int64 `json:"revision_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	book, err := app.models.Books.GetForUpdate(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+
+	rev, err := app.models.Revisions.Get(id, input.RevisionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("revision_id", "must refer to a revision of this book")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	snapshot, err := rev.Book()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	book.Title = snapshot.Title
+	book.Year = snapshot.Year
+	book.Author = snapshot.Author
+	book.Authors = nil
+	book.Genres = snapshot.Genres
+	book.Price = snapshot.Price
+	book.PublisherID = snapshot.PublisherID
+	book.ISBN10, book.ISBN13 = "", ""
+	if snapshot.ISBN10 != nil {
+		book.ISBN10 = *snapshot.ISBN10
+	}
+	if snapshot.ISBN13 != nil {
+		book.ISBN13 = *snapshot.ISBN13
+	}
+
+	// The book goes back to the same authors, as long as they still exist.
+	if len(snapshot.AuthorIDs) > 0 {
+		book.Authors, err = app.lookupAuthors(v, snapshot.AuthorIDs)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Check the old state against the current rules, and the current genre taxonomy.
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateBook(v, book, taxonomy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Books.Revert(book, rev.ID, app.contextGetUser(r).ID)
+	if err != nil {
+		var constraintErr *data.ConstraintError
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.As(err, &constraintErr):
+			app.constraintViolationResponse(w, r, constraintErr)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", bookETag(book))
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"book": book}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
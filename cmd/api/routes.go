@@ -1,6 +1,8 @@
 package main
 
 import (
+	"expvar"
+	"finalProjectAdvancedP/internal/storage"
 	"github.com/julienschmidt/httprouter"
 	"net/http"
 )
@@ -18,21 +20,72 @@ func (app *application) routes() http.Handler {
 
 	// now register relevant methods and handlers for our endpoints
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthCheckHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/books", app.createBookHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/books", app.idempotent(app.createBookHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/books/:id", app.showBookHandler)
 	router.HandlerFunc(http.MethodPatch, "/v1/books/:id", app.updateBookHandler)
 	router.HandlerFunc(http.MethodDelete, "/v1/books/:id", app.deleteBookHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/books", app.listBooksHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/books/:id/cover", app.requireAdminUser(app.updateBookCoverHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/books/:id/restore", app.requireAdminUser(app.restoreBookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/books/:id/history", app.requireAdminUser(app.listBookHistoryHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/books/:id/revert", app.requireAdminUser(app.revertBookHandler))
 
-	router.HandlerFunc(http.MethodPost, "/v1/cart", app.addToCartHandler)
+	// Covers kept on the local filesystem are served by the API itself.
+	if local, ok := app.storage.(*storage.Local); ok {
+		router.Handler(http.MethodGet, "/v1/covers/*filepath", app.serveCovers(local))
+	}
+
+	router.HandlerFunc(http.MethodGet, "/v1/authors", app.listAuthorsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/authors", app.requireAdminUser(app.idempotent(app.createAuthorHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/authors/:id", app.showAuthorHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/authors/:id", app.requireAdminUser(app.updateAuthorHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/authors/:id", app.requireAdminUser(app.deleteAuthorHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/publishers", app.listPublishersHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/publishers", app.requireAdminUser(app.idempotent(app.createPublisherHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/publishers/:id", app.showPublisherHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/publishers/:id", app.requireAdminUser(app.updatePublisherHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/publishers/:id", app.requireAdminUser(app.deletePublisherHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.listGenresHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requireAdminUser(app.idempotent(app.createGenreHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/genres/:id", app.showGenreHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/genres/:id", app.requireAdminUser(app.updateGenreHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/genres/:id", app.requireAdminUser(app.deleteGenreHandler))
+
+	// Clients may send an Idempotency-Key header with the write requests they are likely
+	// to retry, so that a retry doesn't create a second record or order.
+	router.HandlerFunc(http.MethodPost, "/v1/cart", app.idempotent(app.addToCartHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/cart", app.deleteBookFromCartHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/cart", app.listBooksInCartHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/cart/order", app.orderBookHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/cart/order", app.idempotent(app.orderBookHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
 
-	// return router instance
-	return app.recoverPanic(app.rateLimit(app.authenticate(router)))
+	// Application metrics, such as the catalog cache's hit and miss counts.
+	router.HandlerFunc(http.MethodGet, "/debug/vars", app.requireAdminUser(expvar.Handler().ServeHTTP))
+
+	// httprouter doesn't allow a fixed path segment in the same position as a named
+	// parameter (such as /v1/books/suggest next to /v1/books/:id). So we register fixed
+	// sub-resources like this on a separate router, which is consulted first and passes
+	// any request it doesn't have a route for on to the main router.
+	fixed := httprouter.New()
+	fixed.HandleMethodNotAllowed = false
+	fixed.NotFound = router
+
+	fixed.HandlerFunc(http.MethodGet, "/v1/books/suggest", app.suggestBooksHandler)
+	fixed.HandlerFunc(http.MethodGet, "/v1/books/isbn/:isbn", app.showBookByISBNHandler)
+	fixed.HandlerFunc(http.MethodGet, "/v1/books/export", app.exportBooksHandler)
+	fixed.HandlerFunc(http.MethodPost, "/v1/books/import", app.requireAdminUser(app.importBooksHandler))
+	fixed.HandlerFunc(http.MethodPost, "/v1/books/batch", app.requireAdminUser(app.idempotent(app.batchBooksHandler)))
+
+	// return router instance. Compression wraps everything else, so that every response
+	// can be compressed. The security headers and CORS come next, so that they are on
+	// every response, errors and all. Then the client IP address is worked out, for
+	// everything after it to use, and requests for a type we can't send are turned away.
+	// Requests are rate limited by IP address before authentication, so that bad tokens
+	// are limited too, and authenticated users by user id after it.
+	return app.recoverPanic(app.compress(app.secureHeaders(app.enableCORS(app.resolveClientIP(app.requireAcceptableType(app.rateLimitIP(app.authenticate(app.rateLimitUser(fixed)))))))))
 }
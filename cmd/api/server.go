@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -20,6 +24,29 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// When a certificate is configured, serve HTTPS, and optionally redirect plain HTTP
+	// requests on another port to it.
+	var redirectSrv *http.Server
+	if app.config.tls.enabled() {
+		srv.TLSConfig = tlsConfig()
+		if app.config.tls.redirectPort != 0 {
+			redirectSrv = &http.Server{
+				Addr:         fmt.Sprintf(":%d", app.config.tls.redirectPort),
+				Handler:      http.HandlerFunc(app.redirectToHTTPS),
+				IdleTimeout:  time.Minute,
+				ReadTimeout:  5 * time.Second,
+				WriteTimeout: 5 * time.Second,
+			}
+			go func() {
+				app.logger.PrintInfo("starting HTTPS redirect server", map[string]string{"addr": redirectSrv.Addr})
+				err := redirectSrv.ListenAndServe()
+				if !errors.Is(err, http.ErrServerClosed) {
+					app.logger.PrintError(err, map[string]string{"addr": redirectSrv.Addr})
+				}
+			}()
+		}
+	}
+
 	shutdownError := make(chan error)
 	go func() {
 		// Intercept the signals, as before.
@@ -34,6 +61,13 @@ func (app *application) serve() error {
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
 
+		if redirectSrv != nil {
+			err := redirectSrv.Shutdown(ctx)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"addr": redirectSrv.Addr})
+			}
+		}
+
 		err := srv.Shutdown(ctx)
 		if err != nil {
 			shutdownError <- err
@@ -56,13 +90,19 @@ func (app *application) serve() error {
 	app.logger.PrintInfo("starting server", map[string]string{
 		"addr": srv.Addr,
 		"env":  app.config.env,
+		"tls":  strconv.FormatBool(app.config.tls.enabled()),
 	})
 
 	// Calling Shutdown() on our server will cause ListenAndServe() to immediately
 	// return a http.ErrServerClosed error. So if we see this error, it is actually a
 	// good thing and an indication that the graceful shutdown has started. So we check
 	// specifically for this, only returning the error if it is NOT http.ErrServerClosed.
-	err := srv.ListenAndServe()
+	var err error
+	if app.config.tls.enabled() {
+		err = srv.ListenAndServeTLS(app.config.tls.certFile, app.config.tls.keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -82,3 +122,44 @@ func (app *application) serve() error {
 	})
 	return nil
 }
+
+// The tlsConfig() function returns the TLS settings for the server. TLS 1.2 is the
+// oldest version accepted, and with it only forward-secret AEAD cipher suites are
+// offered. The TLS 1.3 suites are all modern, and Go doesn't let them be configured.
+func tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	}
+}
+
+// The redirectToHTTPS() handler sends plain HTTP requests to the same address on the
+// HTTPS server. A 308 Permanent Redirect keeps the method and body of the request.
+func (app *application) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if host == "" {
+		http.Error(w, "missing Host header", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case app.config.port != 443:
+		host = net.JoinHostPort(host, strconv.Itoa(app.config.port))
+	case strings.Contains(host, ":"):
+		host = "[" + host + "]" // an IPv6 address
+	}
+
+	w.Header().Set("Connection", "close")
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
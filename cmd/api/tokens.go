@@ -36,6 +36,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			app.logFailedLogin(r, input.Email)
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -53,6 +54,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
 	// helper again and return.
 	if !match {
+		app.logFailedLogin(r, input.Email)
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
@@ -67,8 +69,17 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 
 	// Encode the token to JSON and send it in the response along with a 201 Created
 	// status code.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"authentication_token": token}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// The logFailedLogin() helper logs a failed login, along with the client IP address it
+// came from, so that password guessing can be spotted.
+func (app *application) logFailedLogin(r *http.Request, email string) {
+	app.logger.PrintInfo("failed login attempt", map[string]string{
+		"email":     email,
+		"client_ip": app.contextGetClientIP(r),
+	})
+}
@@ -48,13 +48,12 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	// Insert the user data into the database.
 	err = app.models.Users.Insert(user)
 	if err != nil {
+		var constraintErr *data.ConstraintError
 		switch {
-		// If we get a ErrDuplicateEmail error, use the v.AddError() method to manually
-		// add a message to the validator instance, and then call our
-		// failedValidationResponse() helper.
-		case errors.Is(err, data.ErrDuplicateEmail):
-			v.AddError("email", "a user with this email address already exists")
-			app.failedValidationResponse(w, r, v.Errors)
+		// If the email address is already taken (or any other constraint is violated),
+		// send the field-level message for the violation back to the client.
+		case errors.As(err, &constraintErr):
+			app.constraintViolationResponse(w, r, constraintErr)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -71,7 +70,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	// Write a JSON response containing the user data along with a 201 Created status
 	// code.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -109,7 +108,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	// Note that we also change this to send the client a 202 Accepted status code.
 	// This status code indicates that the request has been accepted for processing, but
 	// the processing has not been completed.
-	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+	w.Header().Set("ETag", versionETag(int32(user.Version)))
+	err = app.writeJSON(w, r, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -162,9 +162,12 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	// the same way that we did for our movie records.
 	err = app.models.Users.Update(user)
 	if err != nil {
+		var constraintErr *data.ConstraintError
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
+		case errors.As(err, &constraintErr):
+			app.constraintViolationResponse(w, r, constraintErr)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -178,7 +181,8 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	// Send the updated user details to the client in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	w.Header().Set("ETag", versionETag(int32(user.Version)))
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
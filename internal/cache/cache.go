@@ -0,0 +1,23 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrMiss is returned by Get when there is no entry for the key, or it has expired.
+var ErrMiss = errors.New("cache: miss")
+
+// Cache is a store of byte values with an expiry, such as encoded query results. Values
+// may be dropped at any time before they expire (when the cache is full, say), so a
+// cache is only ever a shortcut to data which can be fetched again.
+type Cache interface {
+	// Get returns the value stored under key, or ErrMiss.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores the value under key for ttl, replacing any existing value.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the value stored under key. Deleting a key which isn't there is not
+	// an error.
+	Delete(ctx context.Context, key string) error
+}
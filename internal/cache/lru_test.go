@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(2)
+
+	_, err := c.Get(ctx, "a")
+	if !errors.Is(err, ErrMiss) {
+		t.Fatalf("Get() on an empty cache = %v; want ErrMiss", err)
+	}
+
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Set(ctx, "b", []byte("2"), time.Minute)
+	value, err := c.Get(ctx, "a")
+	if err != nil || string(value) != "1" {
+		t.Fatalf("Get(a) = %q, %v; want 1", value, err)
+	}
+
+	// "b" is now the least recently used entry, so it makes room for "c".
+	c.Set(ctx, "c", []byte("3"), time.Minute)
+	if _, err := c.Get(ctx, "b"); !errors.Is(err, ErrMiss) {
+		t.Errorf("Get(b) = %v; want ErrMiss after eviction", err)
+	}
+	for key, want := range map[string]string{"a": "1", "c": "3"} {
+		value, err := c.Get(ctx, key)
+		if err != nil || string(value) != want {
+			t.Errorf("Get(%s) = %q, %v; want %s", key, value, err, want)
+		}
+	}
+
+	// Setting an existing key replaces its value without evicting anything.
+	c.Set(ctx, "a", []byte("one"), time.Minute)
+	if value, _ := c.Get(ctx, "a"); string(value) != "one" {
+		t.Errorf("Get(a) = %q; want one", value)
+	}
+	if _, err := c.Get(ctx, "c"); err != nil {
+		t.Errorf("Get(c) = %v; want a hit", err)
+	}
+
+	c.Delete(ctx, "a")
+	if _, err := c.Get(ctx, "a"); !errors.Is(err, ErrMiss) {
+		t.Errorf("Get(a) = %v; want ErrMiss after Delete()", err)
+	}
+	if err := c.Delete(ctx, "missing"); err != nil {
+		t.Errorf("Delete(missing) = %v", err)
+	}
+}
+
+func TestLRUExpiry(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(10)
+
+	c.Set(ctx, "short", []byte("x"), time.Millisecond)
+	c.Set(ctx, "long", []byte("y"), time.Minute)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := c.Get(ctx, "short"); !errors.Is(err, ErrMiss) {
+		t.Errorf("Get(short) = %v; want ErrMiss once expired", err)
+	}
+	if len(c.entries) != 1 {
+		t.Errorf("cache holds %d entries; want the expired one dropped", len(c.entries))
+	}
+	if _, err := c.Get(ctx, "long"); err != nil {
+		t.Errorf("Get(long) = %v; want a hit", err)
+	}
+}
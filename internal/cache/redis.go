@@ -0,0 +1,270 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Redis keeps the cache in a Redis server, so that it is shared by every instance of the
+// application. It speaks just enough of the Redis protocol (RESP) for the commands the
+// cache needs, over a small pool of connections.
+type Redis struct {
+	addr     string
+	username string
+	password string
+	db       int
+	prefix   string
+	pool     chan *redisConn
+}
+
+// A redisConn is a connection to the server, with a buffered reader for its replies.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// A RedisError is an error reply from the server.
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// NewRedis returns a cache in the Redis server at rawURL, which has the form
+// redis://[[username]:password@]host[:port][/db]. Every key is given the prefix, so
+// that several applications can share a server. At most poolSize idle connections are
+// kept open. No connection is made until the cache is first used.
+func NewRedis(rawURL, prefix string, poolSize int) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("cache: invalid Redis URL %q", rawURL)
+	}
+
+	c := &Redis{
+		addr:   u.Host,
+		prefix: prefix,
+		pool:   make(chan *redisConn, poolSize),
+	}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		c.db, err = strconv.Atoi(path)
+		if err != nil {
+			return nil, fmt.Errorf("cache: invalid Redis database %q", path)
+		}
+	}
+	return c, nil
+}
+
+func (c *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := c.Do(ctx, "GET", c.prefix+key)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrMiss
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("cache: unexpected reply to GET: %v", reply)
+	}
+	return value, nil
+}
+
+func (c *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := c.Do(ctx, "SET", c.prefix+key, value, "PX", ttl.Milliseconds())
+	return err
+}
+
+func (c *Redis) Delete(ctx context.Context, key string) error {
+	_, err := c.Do(ctx, "DEL", c.prefix+key)
+	return err
+}
+
+// The Do() method sends a command to the server and returns its reply. Arguments may be
+// strings, byte slices or integers. Replies are returned as a string (for status
+// replies), int64, []byte, []any or nil, and error replies as a RedisError.
+func (c *Redis) Do(ctx context.Context, args ...any) (any, error) {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(ctx, args...)
+	// A connection which failed for any other reason than an error reply may be left
+	// half way through a reply, so it can't be used again.
+	var redisErr RedisError
+	if err != nil && !errors.As(err, &redisErr) {
+		conn.conn.Close()
+		return nil, err
+	}
+
+	select {
+	case c.pool <- conn:
+	default:
+		conn.conn.Close()
+	}
+	return reply, err
+}
+
+// The conn() method takes an idle connection from the pool, or else opens a new one.
+func (c *Redis) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-c.pool:
+		return conn, nil
+	default:
+	}
+
+	var dialer net.Dialer
+	netConn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{conn: netConn, r: bufio.NewReader(netConn)}
+
+	if c.password != "" {
+		args := []any{"AUTH", c.password}
+		if c.username != "" {
+			args = []any{"AUTH", c.username, c.password}
+		}
+		_, err = conn.do(ctx, args...)
+		if err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		_, err = conn.do(ctx, "SELECT", c.db)
+		if err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Close closes the idle connections.
+func (c *Redis) Close() error {
+	for {
+		select {
+		case conn := <-c.pool:
+			conn.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// The do() method writes a command as an array of bulk strings, and reads the reply.
+func (conn *redisConn) do(ctx context.Context, args ...any) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	err := conn.conn.SetDeadline(deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		var b []byte
+		switch arg := arg.(type) {
+		case string:
+			b = []byte(arg)
+		case []byte:
+			b = arg
+		case int:
+			b = strconv.AppendInt(nil, int64(arg), 10)
+		case int64:
+			b = strconv.AppendInt(nil, arg, 10)
+		default:
+			return nil, fmt.Errorf("cache: unsupported Redis argument type %T", arg)
+		}
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(b)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, b...)
+		buf = append(buf, "\r\n"...)
+	}
+
+	_, err = conn.conn.Write(buf)
+	if err != nil {
+		return nil, err
+	}
+	return conn.readReply()
+}
+
+// The readReply() method reads one reply in the RESP2 format.
+func (conn *redisConn) readReply() (any, error) {
+	line, err := conn.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("cache: malformed Redis reply %q", line)
+	}
+	kind, rest := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, RedisError(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		_, err = io.ReadFull(conn.r, b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		// An error reply inside an array is kept as an item, so that the rest of the
+		// array is still read.
+		for i := range items {
+			items[i], err = conn.readReply()
+			var redisErr RedisError
+			if errors.As(err, &redisErr) {
+				items[i] = redisErr
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("cache: malformed Redis reply %q", line)
+	}
+}
@@ -0,0 +1,37 @@
+package data
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// The pgx stdlib adapter hands PostgreSQL arrays to database/sql in their text form, so
+// we need a pgtype.Map to decode them into native Go slices. A pgtype.Map caches scan
+// plans and isn't safe for concurrent use, so we keep a pool of them and borrow one for
+// the duration of each scan.
+var typeMaps = sync.Pool{
+	New: func() any {
+		return pgtype.NewMap()
+	},
+}
+
+// arrayScanner implements the sql.Scanner interface for a pointer to a Go slice.
+type arrayScanner struct {
+	dst any
+}
+
+func (s arrayScanner) Scan(src any) error {
+	m := typeMaps.Get().(*pgtype.Map)
+	defer typeMaps.Put(m)
+
+	return m.SQLScanner(s.dst).Scan(src)
+}
+
+// The scanArray() helper wraps a pointer to a slice (for example &book.Genres) so that it
+// can be used as a destination in Scan(). Slices passed as query arguments don't need any
+// wrapping, as pgx encodes them natively.
+func scanArray(dst any) sql.Scanner {
+	return arrayScanner{dst: dst}
+}
@@ -0,0 +1,277 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"finalProjectAdvancedP/internal/validator"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Author struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Bio       string    `json:"bio,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateAuthor(v *validator.Validator, author *Author) {
+	v.Check(strings.TrimSpace(author.Name) != "", "name", "must be provided")
+	v.Check(len(author.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(author.Bio) <= 5000, "bio", "must not be more than 5000 bytes long")
+}
+
+// Define an AuthorModel struct type which wraps a sql.DB connection pool.
+type AuthorModel struct {
+	DB    *sql.DB
+	Cache *BookCache
+}
+
+func (m AuthorModel) Insert(author *Author) error {
+	query := `
+		INSERT INTO authors (name, bio)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version`
+	args := []any{strings.TrimSpace(author.Name), author.Bio}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&author.ID, &author.CreatedAt, &author.Version)
+	if err != nil {
+		return translateError(err)
+	}
+	return nil
+}
+
+func (m AuthorModel) Get(id int64) (*Author, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, bio, version
+		FROM authors
+		WHERE id = $1`
+	var author Author
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&author.ID,
+		&author.CreatedAt,
+		&author.Name,
+		&author.Bio,
+		&author.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &author, nil
+}
+
+// The GetMany() method returns the authors with the given ids, in the same order as
+// the ids. If any of them doesn't exist, it returns ErrRecordNotFound.
+func (m AuthorModel) GetMany(ids []int64) ([]*Author, error) {
+	query := `
+		SELECT id, created_at, name, bio, version
+		FROM authors
+		WHERE id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[int64]*Author)
+	for rows.Next() {
+		var author Author
+		err := rows.Scan(&author.ID, &author.CreatedAt, &author.Name, &author.Bio, &author.Version)
+		if err != nil {
+			return nil, err
+		}
+		byID[author.ID] = &author
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	authors := make([]*Author, 0, len(ids))
+	for _, id := range ids {
+		author, ok := byID[id]
+		if !ok {
+			return nil, ErrRecordNotFound
+		}
+		authors = append(authors, author)
+	}
+	return authors, nil
+}
+
+func (m AuthorModel) GetAll(name string, filters Filters) ([]*Author, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, bio, version
+		FROM authors
+		WHERE name ILIKE $1
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+	args := []any{"%" + escapeLike(name) + "%", filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	authors := []*Author{}
+	for rows.Next() {
+		var author Author
+		err := rows.Scan(&totalRecords, &author.ID, &author.CreatedAt, &author.Name, &author.Bio, &author.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		authors = append(authors, &author)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return authors, metadata, nil
+}
+
+// The Update() method saves changes to an author. As the author's name is also part of
+// the display string in books.author, that is refreshed for all of their books in the
+// same transaction, and the change is recorded in their revision history as made by the
+// user with userID.
+func (m AuthorModel) Update(author *Author, userID int64) error {
+	query := `
+		UPDATE authors
+		SET name = $1, bio = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+	args := []any{strings.TrimSpace(author.Name), author.Bio, author.ID, author.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&author.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return translateError(err)
+		}
+	}
+
+	// Only the books whose display string actually changes are touched, so that editing
+	// an author's bio doesn't add revisions to all of their books.
+	query = `
+		UPDATE books
+		SET author = names.author, version = books.version + 1
+		FROM (
+			SELECT book_authors.book_id, string_agg(authors.name, ', ' ORDER BY book_authors.position) AS author
+			FROM book_authors
+			INNER JOIN authors ON authors.id = book_authors.author_id
+			WHERE book_authors.book_id IN (SELECT book_id FROM book_authors WHERE author_id = $1)
+			GROUP BY book_authors.book_id
+		) AS names
+		WHERE books.id = names.book_id AND books.author IS DISTINCT FROM names.author
+		RETURNING books.id`
+	ids, err := queryIDs(ctx, tx, query, author.ID)
+	if err != nil {
+		return err
+	}
+	err = recordRevisions(ctx, tx, RevisionUpdate, userID, nil, ids...)
+	if err != nil {
+		return err
+	}
+
+	// The author is embedded in each of their books, so the cached books are out of
+	// date even where the display string didn't change.
+	return m.Cache.commit(tx)
+}
+
+func (m AuthorModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM authors
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		// Authors can't be deleted while they are still credited on a book.
+		if errors.Is(translateError(err), ErrForeignKeyViolation) {
+			return &ConstraintError{Kind: ErrForeignKeyViolation, Field: "author", Message: "is still credited on one or more books"}
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The findOrCreateAuthor() helper returns the author with the given name (compared
+// case-insensitively), creating them first if necessary. It is used to accept the old
+// free-text author string when creating or updating books. The name is trimmed, and
+// must already have been checked by ValidateBook() not to be blank.
+func findOrCreateAuthor(ctx context.Context, q querier, name string) (*Author, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		panic("unvalidated author name")
+	}
+
+	query := `
+		INSERT INTO authors (name)
+		VALUES ($1)
+		ON CONFLICT ((lower(name))) DO UPDATE SET name = authors.name
+		RETURNING id, created_at, name, bio, version`
+	var author Author
+
+	err := q.QueryRowContext(ctx, query, name).Scan(
+		&author.ID,
+		&author.CreatedAt,
+		&author.Name,
+		&author.Bio,
+		&author.Version,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &author, nil
+}
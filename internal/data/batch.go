@@ -0,0 +1,89 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The operations which can be carried out on a book in a batch.
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// A BookOperation is one operation in a batch. For a delete only the book's ID and
+// Version are used, and a Version of 0 deletes the book whatever its version. Err holds
+// the operation's error once the batch has been run.
+type BookOperation struct {
+	Op   string
+	Book *Book
+	Err  error
+}
+
+// The run() method carries out the operation within the caller's transaction.
+func (op *BookOperation) run(ctx context.Context, q querier, userID int64) error {
+	switch op.Op {
+	case BatchCreate:
+		return insertBook(ctx, q, op.Book, userID)
+	case BatchUpdate:
+		return updateBook(ctx, q, op.Book, userID, RevisionUpdate, nil)
+	case BatchDelete:
+		return deleteBook(ctx, q, op.Book.ID, op.Book.Version, userID)
+	default:
+		return fmt.Errorf("unknown batch operation %q", op.Op)
+	}
+}
+
+// The Batch() method carries out the operations in order, as made by the user with
+// userID. If atomic is true they are run in one transaction, which stops at the first
+// operation to fail, so that either every operation is applied or none is. Otherwise
+// each operation is run in its own transaction, and the others go ahead whether or not
+// it fails. The outcome of each operation is left in its Err field; the error returned
+// is only for failures of the batch as a whole.
+func (m BookModel) Batch(ops []*BookOperation, atomic bool, userID int64) error {
+	if !atomic {
+		for _, op := range ops {
+			op.Err = m.runOperation(op, userID)
+		}
+		m.Cache.invalidate()
+		return nil
+	}
+
+	// A batch may hold many operations, so it gets longer than the usual 3 seconds.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, op := range ops {
+		op.Err = op.run(ctx, tx, userID)
+		if op.Err != nil {
+			return nil
+		}
+	}
+	return m.Cache.commit(tx)
+}
+
+// The runOperation() method carries out a single operation in a transaction of its own.
+func (m BookModel) runOperation(op *BookOperation, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = op.run(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
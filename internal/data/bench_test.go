@@ -0,0 +1,117 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// The benchmarks run the hot catalog and authentication queries against the database
+// in BOOKSTORE_TEST_DSN, once with pgx's statement cache (as the API uses it) and once
+// executing every query afresh, to compare the throughput of the two. For example:
+//
+//	BOOKSTORE_TEST_DSN=postgres://... go test ./internal/data -run '^$' -bench . -benchmem
+//
+// They are skipped when BOOKSTORE_TEST_DSN isn't set.
+var benchExecModes = []struct {
+	name string
+	mode pgx.QueryExecMode
+}{
+	{"cache_statement", pgx.QueryExecModeCacheStatement},
+	{"exec", pgx.QueryExecModeExec},
+}
+
+// The openBenchDB() helper opens a connection pool to the test database using the given
+// query execution mode.
+func openBenchDB(b *testing.B, mode pgx.QueryExecMode) *sql.DB {
+	b.Helper()
+
+	dsn := os.Getenv("BOOKSTORE_TEST_DSN")
+	if dsn == "" {
+		b.Skip("BOOKSTORE_TEST_DSN not set")
+	}
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	connConfig.DefaultQueryExecMode = mode
+	connConfig.StatementCacheCapacity = 512
+
+	db := stdlib.OpenDB(*connConfig)
+	b.Cleanup(func() { db.Close() })
+	if err := db.Ping(); err != nil {
+		b.Fatal(err)
+	}
+	return db
+}
+
+func BenchmarkBookModelGet(b *testing.B) {
+	for _, m := range benchExecModes {
+		b.Run(m.name, func(b *testing.B) {
+			db := openBenchDB(b, m.mode)
+			books := BookModel{DB: db, Replicas: NewReplicas(db)}
+
+			var id int64
+			err := db.QueryRow("SELECT id FROM books WHERE deleted_at IS NULL LIMIT 1").Scan(&id)
+			if errors.Is(err, sql.ErrNoRows) {
+				b.Skip("no books in the test database")
+			} else if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := books.Get(id)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkBookModelGetAll(b *testing.B) {
+	filters := Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}}
+
+	for _, m := range benchExecModes {
+		b.Run(m.name, func(b *testing.B) {
+			db := openBenchDB(b, m.mode)
+			books := BookModel{DB: db, Replicas: NewReplicas(db)}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _, err := books.GetAll(BookSearch{}, filters)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkUserModelGetForToken(b *testing.B) {
+	// The token doesn't need to exist: the query is parsed and planned all the same.
+	token, err := generateToken(1, time.Hour, ScopeAuthentication)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, m := range benchExecModes {
+		b.Run(m.name, func(b *testing.B) {
+			users := UserModel{DB: openBenchDB(b, m.mode)}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := users.GetForToken(ScopeAuthentication, token.Plaintext)
+				if err != nil && !errors.Is(err, ErrRecordNotFound) {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
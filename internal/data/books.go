@@ -6,27 +6,82 @@ import (
 	"errors"
 	"finalProjectAdvancedP/internal/validator"
 	"fmt"
-	"github.com/lib/pq"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 )
 
 // Annotate the Book struct with struct tags to control how the keys appear in the
 // JSON-encoded output.
 
+// Author holds the display string for the book's authors (their names, separated by
+// commas), which is kept for compatibility and used for searching. The authors
+// themselves are in Authors. When a book is saved without any Authors, the Author
+// string is taken as the name of a single author, who is created if necessary.
+//
+// Genres holds the slugs of the book's genres, which is what is stored. Clients have
+// always been sent the genres' display names under "genres", so those are looked up
+// into GenreNames, and the slugs are sent alongside as "genre_slugs".
 type Book struct {
-	Author    string    `json:"author"`
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"` // Use the - directive in order to hide this info from JSON response
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`   // Add the omitempty directive to output this info only if it is not empty
-	Genres    []string  `json:"genres,omitempty"` // Add the omitempty directive
-	Price     uint64    `json:"price"`
-	Version   int32     `json:"version"`
-}
-
-func ValidateBook(v *validator.Validator, book *Book) {
-	v.Check(book.Author != "", "author", "must be provided")
-	v.Check(len(book.Author) <= 100, "author", "must not be more than 100 bytes long")
+	Author      string     `json:"author"`
+	Authors     []*Author  `json:"authors,omitempty"`
+	ID          int64      `json:"id"`
+	CreatedAt   time.Time  `json:"-"` // Use the - directive in order to hide this info from JSON response
+	Title       string     `json:"title"`
+	Year        int32      `json:"year,omitempty"`   // Add the omitempty directive to output this info only if it is not empty
+	GenreNames  []string   `json:"genres,omitempty"` // Add the omitempty directive
+	Genres      []string   `json:"genre_slugs,omitempty"`
+	Price       uint64     `json:"price"`
+	PublisherID *int64     `json:"-"`
+	Publisher   *Publisher `json:"publisher,omitempty"`
+	ISBN10      string     `json:"isbn10,omitempty"`
+	ISBN13      string     `json:"isbn13,omitempty"`
+	CoverURL    string     `json:"cover_url,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	Version     int32      `json:"version"`
+}
+
+// bookColumns lists the columns selected for a Book, in the same order as the targets
+// returned by scanTargets(). The ISBNs and cover URL are NULL when unknown, which we
+// read as "".
+const bookColumns = `id, created_at, title, year, author, genres, price, publisher_id,
+		COALESCE(isbn10, ''), COALESCE(isbn13, ''), COALESCE(cover_url, ''), deleted_at, version`
+
+// The scanTargets() method returns the scan destinations for the columns in
+// bookColumns. Notice that we need to convert the scan target for the genres column
+// using the scanArray() helper.
+func (book *Book) scanTargets() []any {
+	return []any{
+		&book.ID,
+		&book.CreatedAt,
+		&book.Title,
+		&book.Year,
+		&book.Author,
+		scanArray(&book.Genres),
+		&book.Price,
+		&book.PublisherID,
+		&book.ISBN10,
+		&book.ISBN13,
+		&book.CoverURL,
+		&book.DeletedAt,
+		&book.Version,
+	}
+}
+
+// ValidateBook checks the book's fields, including that its genres are all part of the
+// genre taxonomy.
+func ValidateBook(v *validator.Validator, book *Book, taxonomy *Taxonomy) {
+	// Without a list of authors, the author string is the name of the book's single
+	// author, who is looked up by that name once it has been trimmed.
+	if len(book.Authors) == 0 {
+		v.Check(strings.TrimSpace(book.Author) != "", "author", "must be provided")
+		v.Check(len(strings.TrimSpace(book.Author)) <= 100, "author", "must not be more than 100 bytes long")
+	}
+	for _, author := range book.Authors {
+		v.Check(strings.TrimSpace(author.Name) != "", "authors", "must not contain empty names")
+	}
+	v.Check(len(book.Authors) <= 10, "author_ids", "must not contain more than 10 authors")
 	v.Check(book.Title != "", "title", "must be provided")
 	v.Check(len(book.Title) <= 500, "title", "must not be more than 500 bytes long")
 	v.Check(book.Year != 0, "year", "must be provided")
@@ -35,42 +90,296 @@ func ValidateBook(v *validator.Validator, book *Book) {
 	v.Check(book.Genres != nil, "genres", "must be provided")
 	v.Check(len(book.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(book.Genres) <= 5, "genres", "must not contain more than 5 genres")
-	v.Check(validator.Unique(book.Genres), "genres", "must not contain duplicate values")
+	ValidateGenres(v, book.Genres, taxonomy)
 	v.Check(book.Price > 0, "price", "must be greater than zero")
+
+	// ISBNs are optional, but if given they must have a correct check digit, and when
+	// both forms are given they must refer to the same book.
+	if book.ISBN10 != "" {
+		v.Check(validator.ValidISBN10(book.ISBN10), "isbn10", "must be a valid ISBN-10")
+	}
+	if book.ISBN13 != "" {
+		v.Check(validator.ValidISBN13(book.ISBN13), "isbn13", "must be a valid ISBN-13")
+	}
+	if isbn13, ok := validator.ISBN10To13(book.ISBN10); ok && validator.ValidISBN13(book.ISBN13) {
+		v.Check(isbn13 == book.ISBN13, "isbn13", "must match isbn10")
+	}
+}
+
+// The fillISBNs() method derives whichever of the book's ISBNs is missing from the
+// other one, where possible. ISBN-13s in the 979 range have no ISBN-10 equivalent.
+func (book *Book) fillISBNs() {
+	if book.ISBN13 == "" {
+		book.ISBN13, _ = validator.ISBN10To13(book.ISBN10)
+	}
+	if book.ISBN10 == "" {
+		book.ISBN10, _ = validator.ISBN13To10(book.ISBN13)
+	}
+}
+
+// The BookSearch struct holds the criteria for searching the catalog. Each field left
+// at its zero value means that the corresponding filter isn't applied.
+type BookSearch struct {
+	Title    string
+	Author   string
+	Query    string // free-text search over both title and author
+	Genres   []string
+	MinPrice int64
+	MaxPrice int64
+	MinYear  int
+	MaxYear  int
+	// Fuzzy makes the title, author and free-text filters also match values which are
+	// merely similar to the search terms (for example "Dostoyevsky" and "Dostoevsky"),
+	// using trigram similarity. Similarity is the minimum similarity for a match.
+	Fuzzy      bool
+	Similarity float64
+	// IncludeDeleted also matches books which have been deleted but not yet purged.
+	IncludeDeleted bool
 }
 
-// Define a BookModel struct type which wraps a sql.DB connection pool.
+func ValidateBookSearch(v *validator.Validator, search BookSearch) {
+	v.Check(len(search.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(len(search.Author) <= 100, "author", "must not be more than 100 bytes long")
+	v.Check(len(search.Query) <= 500, "q", "must not be more than 500 bytes long")
+	v.Check(search.MinPrice >= 0, "min_price", "must not be negative")
+	v.Check(search.MaxPrice >= 0, "max_price", "must not be negative")
+	v.Check(search.MinPrice <= math.MaxInt32, "min_price", "must not be more than 2147483647")
+	v.Check(search.MaxPrice <= math.MaxInt32, "max_price", "must not be more than 2147483647")
+	v.Check(search.MaxPrice == 0 || search.MinPrice <= search.MaxPrice, "max_price", "must not be less than min_price")
+	v.Check(search.MinYear >= 0, "min_year", "must not be negative")
+	v.Check(search.MaxYear >= 0, "max_year", "must not be negative")
+	v.Check(search.MaxYear == 0 || search.MinYear <= search.MaxYear, "max_year", "must not be less than min_year")
+	v.Check(search.Similarity >= 0 && search.Similarity <= 1, "similarity", "must be between 0 and 1")
+}
+
+// The where() method returns the WHERE clause matching the search criteria, along with
+// its arguments. A book matches a genre if it is in that genre or any genre below it in
+// the taxonomy, and genres are matched by slug, regardless of how they were written.
+// Fuzzy matches use the <% operator, which the trigram indexes can serve, so the
+// queries must be run in a transaction begun with beginSearch(), which sets the
+// threshold it compares against. The free-text query is always the last argument, so
+// that it can also be referred to when ranking the results by relevance. Deleted books
+// are left out unless the search asks for them.
+func (search BookSearch) where() (string, []any) {
+	where := `
+WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR ($8 AND $1 <% title) OR $1 = '')
+AND ($2::text[] = '{}' OR (
+	SELECT count(DISTINCT genre_descendants.ancestor)
+	FROM genre_descendants
+	WHERE genre_descendants.ancestor = ANY($2) AND genre_descendants.slug = ANY(books.genres)
+) = cardinality($2::text[]))
+AND (to_tsvector('simple', author) @@ plainto_tsquery('simple', $3) OR ($8 AND $3 <% author) OR $3 = '')
+AND (price >= $4 OR $4 = 0)
+AND (price <= $5 OR $5 = 0)
+AND (year >= $6 OR $6 = 0)
+AND (year <= $7 OR $7 = 0)
+AND (search @@ websearch_to_tsquery('simple', $9)
+	OR ($8 AND ($9 <% title OR $9 <% author))
+	OR $9 = '')`
+	if !search.IncludeDeleted {
+		where += "\nAND books.deleted_at IS NULL"
+	}
+	args := []any{
+		search.Title,
+		genreSlugs(search.Genres),
+		search.Author,
+		search.MinPrice,
+		search.MaxPrice,
+		search.MinYear,
+		search.MaxYear,
+		search.Fuzzy,
+		search.Query,
+	}
+	return where, args
+}
+
+// The beginSearch() method begins a read-only transaction to run the search's queries
+// in. For fuzzy searches, it sets the similarity threshold used by where() for the
+// length of the transaction.
+func (search BookSearch) beginSearch(ctx context.Context, db *sql.DB, opts sql.TxOptions) (*sql.Tx, error) {
+	opts.ReadOnly = true
+	tx, err := db.BeginTx(ctx, &opts)
+	if err != nil {
+		return nil, err
+	}
+	if search.Fuzzy {
+		err = setSimilarityThreshold(ctx, tx, search.Similarity)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return tx, nil
+}
+
+// The setSimilarityThreshold() function sets pg_trgm.word_similarity_threshold, which
+// the <% and %> operators compare against, until the end of the current transaction.
+func setSimilarityThreshold(ctx context.Context, q querier, threshold float64) error {
+	_, err := q.ExecContext(ctx, `SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`,
+		strconv.FormatFloat(threshold, 'f', -1, 64))
+	return err
+}
+
+// The rank() method returns the expression used to sort by relevance, given the
+// position of the free-text query argument. Fuzzy matches don't match the tsvector at
+// all, so for fuzzy searches we also add in their trigram similarity. This is only
+// worked out for the books which passed the filters.
+func (search BookSearch) rank(arg int) string {
+	rank := fmt.Sprintf("ts_rank(search, websearch_to_tsquery('simple', $%d))", arg)
+	if search.Fuzzy {
+		rank += fmt.Sprintf(" + greatest(word_similarity($%[1]d, title), word_similarity($%[1]d, author))", arg)
+	}
+	return rank
+}
+
+// Define a BookModel struct type which wraps a sql.DB connection pool. Writes always go
+// to DB (the primary), while catalog reads are routed through Replicas.
 type BookModel struct {
-	DB *sql.DB
+	DB       *sql.DB
+	Replicas *Replicas
+	Cache    *BookCache
 }
 
 // Add a placeholder method for inserting a new record in the movies table.
 // The Insert() method accepts a pointer to a movie struct, which should contain the
-// data for the new record.
-func (m BookModel) Insert(book *Book) error {
+// data for the new record. The book's authors are linked to it in the same
+// transaction, and its authors and publisher are loaded into the struct afterwards. The
+// new book is recorded in its revision history as created by the user with userID.
+func (m BookModel) Insert(book *Book, userID int64) error {
+	// Create a context with a 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertBook(ctx, tx, book, userID)
+	if err != nil {
+		return err
+	}
+	return m.Cache.commit(tx)
+}
+
+// The insertBook() helper does the work of Insert() within the caller's transaction.
+func insertBook(ctx context.Context, q querier, book *Book, userID int64) error {
 	// Define the SQL query for inserting a new record in the movies table and returning
 	// the system-generated data.
 	query := `
-		INSERT INTO books (title, year, genres, author, price)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO books (title, year, genres, author, price, publisher_id, isbn10, isbn13)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''))
 		RETURNING id, created_at, version`
+
+	err := resolveAuthors(ctx, q, book)
+	if err != nil {
+		return err
+	}
+
 	// Create an args slice containing the values for the placeholder parameters from
 	// the movie struct. Declaring this slice immediately next to our SQL query helps to
 	// make it nice and clear *what values are being used where* in the query.
+	book.fillISBNs()
+	book.Genres = genreSlugs(book.Genres)
+	args := []any{book.Title, book.Year, book.Genres, book.Author, book.Price, book.PublisherID, book.ISBN10, book.ISBN13}
 
-	// Create a context with a 3-second timeout.
-	args := []any{book.Title, book.Year, pq.Array(book.Genres), book.Author, book.Price}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	// Use the QueryRow() method to execute the SQL query on our connection pool,
-	// passing in the args slice as a variadic parameter and scanning the system-
-	// generated id, created_at and version values into the movie struct.
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.CreatedAt, &book.Version)
+	// Check constraint violations (for example a non-positive price) are translated into
+	// a *ConstraintError so the handler can report them against the right field.
+	err = q.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.CreatedAt, &book.Version)
+	if err != nil {
+		return translateError(err)
+	}
 
+	err = linkAuthors(ctx, q, book)
+	if err != nil {
+		return err
+	}
+	err = recordRevisions(ctx, q, RevisionCreate, userID, nil, book.ID)
+	if err != nil {
+		return err
+	}
+	return loadRelations(ctx, q, book)
 }
 
-// Add a placeholder method for fetching a specific record from the movies table.
+// The Get() method fetches a specific record from the books table. As this is a
+// read-only catalog query, it is served from the cache, or by one of the read replicas
+// where possible (see readUncached()).
 func (m BookModel) Get(id int64) (*Book, error) {
+	var book *Book
+	// Look in the cache first. Only books which were found are cached.
+	fill, hit := m.Cache.lookup("book:"+strconv.FormatInt(id, 10), &book)
+	if hit {
+		return book, nil
+	}
+
+	err := m.readUncached(fill, func(db *sql.DB) error {
+		var err error
+		book, err = m.get(db, id, false)
+		return err
+	})
+	if err == nil {
+		m.Cache.store(fill, book)
+	}
+	return book, err
+}
+
+// The GetIncludingDeleted() method is like Get(), but also finds books which have been
+// deleted and not yet purged. It is meant for admins.
+func (m BookModel) GetIncludingDeleted(id int64) (*Book, error) {
+	var book *Book
+	err := m.Replicas.read(func(db *sql.DB) error {
+		var err error
+		book, err = m.get(db, id, true)
+		return err
+	})
+	return book, err
+}
+
+// The GetForUpdate() method fetches a specific record from the primary. Use it whenever
+// the book is about to be modified, so that we never start from a stale copy on a
+// replica which hasn't caught up with our own writes yet.
+func (m BookModel) GetForUpdate(id int64) (*Book, error) {
+	return m.get(m.DB, id, false)
+}
+
+// The GetByISBN() method looks up a book by either its ISBN-10 or its ISBN-13. The isbn
+// should already be normalized. An ISBN-10 is converted to its ISBN-13 first, so that
+// a book which was only saved with its ISBN-13 is still found.
+func (m BookModel) GetByISBN(isbn string) (*Book, error) {
+	isbn13 := isbn
+	if converted, ok := validator.ISBN10To13(isbn); ok {
+		isbn13 = converted
+	}
+
+	query := `
+		SELECT id
+		FROM books
+		WHERE (isbn13 = $1 OR isbn10 = $2) AND deleted_at IS NULL
+		LIMIT 1`
+
+	var book *Book
+	err := m.Replicas.read(func(db *sql.DB) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		var id int64
+		err := db.QueryRowContext(ctx, query, isbn13, isbn).Scan(&id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrRecordNotFound
+			}
+			return err
+		}
+		book, err = m.get(db, id, false)
+		return err
+	})
+	return book, err
+}
+
+// The get() helper fetches a book from db. Deleted books are only returned when
+// includeDeleted is true.
+func (m BookModel) get(db *sql.DB, id int64, includeDeleted bool) (*Book, error) {
 	// The PostgreSQL bigserial type that we're using for the movie ID starts
 	// auto-incrementing at 1 by default, so we know that no movies will have ID values
 	// less than that. To avoid making an unnecessary database call, we take a shortcut
@@ -80,9 +389,9 @@ func (m BookModel) Get(id int64) (*Book, error) {
 	}
 	// Define the SQL query for retrieving the movie data.
 	query := `
-		SELECT id, created_at, title, year, author, genres, price, version
+		SELECT ` + bookColumns + `
 		FROM books
-		WHERE id = $1`
+		WHERE id = $1 AND (deleted_at IS NULL OR $2)`
 	// Declare a Movie struct to hold the data returned by the query.
 	var book Book
 
@@ -98,20 +407,11 @@ func (m BookModel) Get(id int64) (*Book, error) {
 	// Execute the query using the QueryRow() method, passing in the provided id value
 	// as a placeholder parameter, and scan the response data into the fields of the
 	// Movie struct. Importantly, notice that we need to convert the scan target for the
-	// genres column using the pq.Array() adapter function again.
+	// genres column using the scanArray() helper again.
 
 	// Use the QueryRowContext() method to execute the query, passing in the context
 	// with the deadline as the first argument.
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&book.ID,
-		&book.CreatedAt,
-		&book.Title,
-		&book.Year,
-		&book.Author,
-		pq.Array(&book.Genres),
-		&book.Price,
-		&book.Version,
-	)
+	err := db.QueryRowContext(ctx, query, id, includeDeleted).Scan(book.scanTargets()...)
 
 	// Handle any errors. If there was no matching movie found, Scan() will return
 	// a sql.ErrNoRows error. We check for this and return our custom ErrRecordNotFound
@@ -124,76 +424,183 @@ func (m BookModel) Get(id int64) (*Book, error) {
 			return nil, err
 		}
 	}
+
+	// Fill in the book's authors and publisher.
+	err = loadRelations(ctx, db, &book)
+	if err != nil {
+		return nil, err
+	}
+
 	// Otherwise, return a pointer to the Movie struct.
 	return &book, nil
 }
 
-// Add a placeholder method for updating a specific record in the movies table.
-func (m BookModel) Update(book *Book) error {
+// Add a placeholder method for updating a specific record in the movies table. The
+// book's author links are replaced with its current Authors in the same transaction, and
+// the change is recorded in its revision history as made by the user with userID.
+func (m BookModel) Update(book *Book, userID int64) error {
+	return m.update(book, userID, RevisionUpdate, nil)
+}
+
+// The Revert() method saves a book which has been set back to the state it was in at
+// the revision with the given id. It works just like Update(), but the revision history
+// records the change as a revert.
+func (m BookModel) Revert(book *Book, revisionID, userID int64) error {
+	return m.update(book, userID, RevisionRevert, &revisionID)
+}
+
+func (m BookModel) update(book *Book, userID int64, action string, revertedFrom *int64) error {
+	// Create a context with a 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateBook(ctx, tx, book, userID, action, revertedFrom)
+	if err != nil {
+		return err
+	}
+	return m.Cache.commit(tx)
+}
+
+// The updateBook() helper does the work of Update() and Revert() within the caller's
+// transaction.
+func updateBook(ctx context.Context, q querier, book *Book, userID int64, action string, revertedFrom *int64) error {
 	// Declare the SQL query for updating the record and returning the new version
 	// number.
 	query := `
 		UPDATE books
-		SET title = $1, year = $2, author = $3, genres = $4, price = $5, version = version + 1
-		WHERE id = $6 AND version = $7
+		SET title = $1, year = $2, author = $3, genres = $4, price = $5, publisher_id = $6,
+			isbn10 = NULLIF($7, ''), isbn13 = NULLIF($8, ''), version = version + 1
+		WHERE id = $9 AND version = $10 AND deleted_at IS NULL
 		RETURNING version`
+
+	err := resolveAuthors(ctx, q, book)
+	if err != nil {
+		return err
+	}
+
 	// Create an args slice containing the values for the placeholder parameters.
+	book.fillISBNs()
+	book.Genres = genreSlugs(book.Genres)
 	args := []any{
 		book.Title,
 		book.Year,
 		book.Author,
-		pq.Array(book.Genres),
+		book.Genres,
 		book.Price,
+		book.PublisherID,
+		book.ISBN10,
+		book.ISBN13,
 		book.ID,
 		book.Version,
 	}
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
 	// Use the QueryRow() method to execute the query, passing in the args slice as a
 	// variadic parameter and scanning the new version value into the movie struct.
 	// Use QueryRowContext() and pass the context as the first argument.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&book.Version)
+	err = q.QueryRowContext(ctx, query, args...).Scan(&book.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return err
+			return translateError(err)
 		}
 	}
-	return nil
+
+	err = linkAuthors(ctx, q, book)
+	if err != nil {
+		return err
+	}
+	err = recordRevisions(ctx, q, action, userID, revertedFrom, book.ID)
+	if err != nil {
+		return err
+	}
+	return loadRelations(ctx, q, book)
+}
+
+// The UpdateCover() method sets the address of the book's cover image. Like Update(), it
+// bumps the version, records the change in the book's revision history, and fails with
+// ErrEditConflict if the book was changed meanwhile.
+func (m BookModel) UpdateCover(book *Book, userID int64) error {
+	query := `
+		UPDATE books
+		SET cover_url = NULLIF($1, ''), version = version + 1
+		WHERE id = $2 AND version = $3 AND deleted_at IS NULL
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, book.CoverURL, book.ID, book.Version).Scan(&book.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return translateError(err)
+		}
+	}
+
+	err = recordRevisions(ctx, tx, RevisionUpdate, userID, nil, book.ID)
+	if err != nil {
+		return translateError(err)
+	}
+	return translateError(m.Cache.commit(tx))
 }
 
-// Add a placeholder method for deleting a specific record from the movies table.
-func (m BookModel) Delete(id int64) error {
+// The Delete() method soft-deletes a book: it is hidden from the catalog, but stays in
+// the database (along with the carts and orders which refer to it) until it is purged.
+// Deleting a book which is already deleted returns ErrRecordNotFound. If version isn't 0,
+// the book is only deleted if it is still at that version, and ErrEditConflict is
+// returned otherwise. The deletion is recorded in the book's revision history as made by
+// the user with userID.
+func (m BookModel) Delete(id int64, version int32, userID int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	// Construct the SQL query to delete the record.
-	query := `
-		DELETE FROM books
-		WHERE id = $1`
-
 	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	// Use ExecContext() and pass the context as the first argument.
-	result, err := m.DB.ExecContext(ctx, query, id)
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deleteBook(ctx, tx, id, version, userID)
 	if err != nil {
 		return err
 	}
+	return m.Cache.commit(tx)
+}
 
-	// Execute the SQL query using the Exec() method, passing in the id variable as
-	// the value for the placeholder parameter. The Exec() method returns a sql.Result
-	// object.
-	//result, err := m.DB.Exec(query, id)
-	//if err != nil {
-	//	return err
-	//}
+// The deleteBook() helper does the work of Delete() within the caller's transaction.
+func deleteBook(ctx context.Context, q querier, id int64, version int32, userID int64) error {
+	// Construct the SQL query to mark the record as deleted.
+	query := `
+		UPDATE books
+		SET deleted_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL AND (version = $2 OR $2 = 0)`
+
+	// Use ExecContext() and pass the context as the first argument.
+	result, err := q.ExecContext(ctx, query, id, version)
+	if err != nil {
+		return err
+	}
 
 	// Call the RowsAffected() method on the sql.Result object to get the number of rows
 	// affected by the query.
@@ -202,35 +609,259 @@ func (m BookModel) Delete(id int64) error {
 		return err
 	}
 
-	// If no rows were affected, we know that the movies table didn't contain a record
-	// with the provided ID at the moment we tried to delete it. In that case we
-	// return an ErrRecordNotFound error.
+	// If no rows were affected, either the books table didn't contain a (not deleted)
+	// record with the provided ID at the moment we tried to delete it, or it was at a
+	// different version from the one we were asked for. Only an existing book can be
+	// in conflict, so check which it was.
 	if rowsAffected == 0 {
+		if version == 0 {
+			return ErrRecordNotFound
+		}
+		var exists bool
+		err = q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM books WHERE id = $1 AND deleted_at IS NULL)`, id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrEditConflict
+		}
 		return ErrRecordNotFound
 	}
-	return nil
+
+	return recordRevisions(ctx, q, RevisionDelete, userID, nil, id)
+}
+
+// The Restore() method undoes the soft deletion of a book. It returns ErrRecordNotFound
+// if there is no deleted book with the id, and a *ConstraintError if another book has
+// taken its ISBN in the meantime. The restore is recorded in the book's revision history.
+func (m BookModel) Restore(id int64, userID int64) (*Book, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE books
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	err = recordRevisions(ctx, tx, RevisionRestore, userID, nil, id)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Cache.commit(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.get(m.DB, id, false)
+}
+
+// The Purge() method permanently deletes the books which were soft-deleted before the
+// cutoff, along with the abandoned (never ordered) cart rows which refer to them. Books
+// which appear in an order are kept, so that the order history stays intact. The purge
+// is recorded as the last revision in each book's history. It returns the number of
+// books purged.
+func (m BookModel) Purge(cutoff time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the books to purge first, so that nobody can add them to a cart or restore
+	// them between the two deletes.
+	query := `
+		SELECT id
+		FROM books
+		WHERE deleted_at < $1
+		AND NOT EXISTS (SELECT 1 FROM carts WHERE carts.book_id = books.id AND carts.ordered)
+		FOR UPDATE SKIP LOCKED`
+	ids, err := queryIDs(ctx, tx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	err = recordRevisions(ctx, tx, RevisionPurge, 0, nil, ids...)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM carts WHERE book_id = ANY($1) AND NOT ordered`, ids)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM books WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return 0, err
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, m.Cache.commit(tx)
 }
 
 // Create a new GetAll() method which returns a slice of movies. Although we're not
 // using them right now, we've set this up to accept the various filter parameters as
 // arguments.
-func (m BookModel) GetAll(title string, genres []string, filters Filters) ([]*Book, Metadata, error) {
-	// Construct the SQL query to retrieve all movie records.
-	// Update the SQL query to include the filter conditions.
-	// Use full-text search for the title filter.
-	// Update the SQL query to include the LIMIT and OFFSET clauses with placeholder
-	// parameter values.
+func (m BookModel) GetAll(search BookSearch, filters Filters) ([]*Book, Metadata, error) {
+	var (
+		books    []*Book
+		metadata Metadata
+	)
+	// Look in the cache first. An empty page comes back from the cache as a nil slice,
+	// which we turn back into an empty one so that it is still encoded as [].
+	var cached cachedBookList
+	fill, hit := m.Cache.lookup(bookListCacheKey(search, filters), &cached)
+	if hit {
+		if cached.Books == nil {
+			cached.Books = []*Book{}
+		}
+		return cached.Books, cached.Metadata, nil
+	}
+
+	// Listing the catalog is read-only, so run it on a read replica where possible.
+	err := m.readUncached(fill, func(db *sql.DB) error {
+		var err error
+		books, metadata, err = m.getAll(db, search, filters)
+		return err
+	})
+	if err == nil {
+		m.Cache.store(fill, cachedBookList{Books: books, Metadata: metadata})
+	}
+	return books, metadata, err
+}
+
+// The LastModified() method returns when the catalog was last changed (see
+// BookRevisionModel.LastModified()). Every such change starts a new cache generation,
+// so the time is cached alongside the lists of books it describes.
+func (m BookModel) LastModified() (time.Time, error) {
+	var lastModifiedAt time.Time
+	fill, hit := m.Cache.lookup("last-modified", &lastModifiedAt)
+	if hit {
+		return lastModifiedAt, nil
+	}
+
+	err := m.readUncached(fill, func(db *sql.DB) error {
+		var err error
+		lastModifiedAt, err = lastModified(db, 0)
+		return err
+	})
+	if err == nil {
+		m.Cache.store(fill, lastModifiedAt)
+	}
+	return lastModifiedAt, err
+}
+
+// The readUncached() helper runs a read which missed the cache, on a read replica where
+// possible. The one exception is a result which is going to be cached in a generation
+// that started less than MaxReplicaLag ago: a replica may not have applied the change
+// which started it yet, and the old result it returned would then be served for the
+// whole TTL, so that is read from the primary. Replicas which fall further behind than
+// that are taken out of rotation by Replicas.MonitorHealth().
+func (m BookModel) readUncached(fill cacheFill, fn func(db *sql.DB) error) error {
+	if fill.key != "" && time.Since(fill.started) < MaxReplicaLag {
+		return fn(m.DB)
+	}
+	return m.Replicas.read(fn)
+}
+
+func (m BookModel) getAll(db *sql.DB, search BookSearch, filters Filters) ([]*Book, Metadata, error) {
+	// Build the filter conditions shared by the listing and count queries.
+	where, args := search.where()
+
+	column, direction := filters.sortColumn(), filters.sortDirection()
+	order := fmt.Sprintf("%s %s, id ASC", column, direction)
+	// Sorting by relevance ranks the books by how well they match the free-text query,
+	// giving matches in the title more weight than matches in the author.
+	if column == "relevance" {
+		order = search.rank(len(args)) + " DESC, id ASC"
+	}
+
+	// In page mode, the total is counted with a window function over the filtered rows,
+	// which avoids a second query. We don't bother when the client has asked us not to.
+	total := "count(*) OVER()"
+	if filters.SkipTotal {
+		total = "0"
+	}
+
+	// In keyset mode, only select the records on the far side of the cursor. The total
+	// then has to come from a separate count query, as the window function would only
+	// count the records past the cursor. The count uses the conditions from before the
+	// cursor was added, so that every page reports the same total.
+	countWhere, countArgs := where, args[:len(args):len(args)]
+	c, keyset := filters.keyset()
+	if keyset {
+		value, err := bookSortValue(column, c.Value)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		columnOp, idOp := filters.keysetCondition(c)
+		where += fmt.Sprintf("\nAND (%[1]s %[2]s $%[4]d OR (%[1]s = $%[4]d AND id %[3]s $%[5]d))", column, columnOp, idOp, len(args)+1, len(args)+2)
+		args = append(args, value, c.ID)
+		total = "0"
+
+		// When paging backwards, walk the index in the opposite direction and reverse
+		// the results afterwards.
+		if c.Before {
+			reverse := "DESC"
+			if direction == "DESC" {
+				reverse = "ASC"
+			}
+			order = fmt.Sprintf("%s %s, id DESC", column, reverse)
+		}
+	}
+
+	// Fetch one record more than the page size, so we know whether there is another
+	// page after this one without having to count.
 	query := fmt.Sprintf(`
-SELECT count(*) OVER(), id, created_at, title, year, author, genres, price, version
-FROM books
-WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-AND (genres @> $2 OR $2 = '{}')
-ORDER BY %s %s, id ASC
-LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+SELECT %s, %s
+FROM books %s
+ORDER BY %s
+LIMIT $%d`, total, bookColumns, where, order, len(args)+1)
+	queryArgs := append(args, filters.limit()+1)
+	if !keyset {
+		query += fmt.Sprintf(" OFFSET $%d", len(queryArgs)+1)
+		queryArgs = append(queryArgs, filters.offset())
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+
+	tx, err := search.beginSearch(ctx, db, sql.TxOptions{})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, queryArgs...)
 	if err != nil {
 		return nil, Metadata{}, err // Update this to return an empty Metadata struct.
 	}
@@ -240,17 +871,9 @@ LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 	books := []*Book{}
 	for rows.Next() {
 		var book Book
-		err := rows.Scan(
-			&totalRecords, // Scan the count from the window function into totalRecords.
-			&book.ID,
-			&book.CreatedAt,
-			&book.Title,
-			&book.Year,
-			&book.Author,
-			pq.Array(&book.Genres),
-			&book.Price,
-			&book.Version,
-		)
+		// Scan the count from the window function into totalRecords, followed by the
+		// book's own columns.
+		err := rows.Scan(append([]any{&totalRecords}, book.scanTargets()...)...)
 		if err != nil {
 			return nil, Metadata{}, err // Update this to return an empty Metadata struct.
 		}
@@ -259,9 +882,261 @@ LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err // Update this to return an empty Metadata struct.
 	}
+
+	more := len(books) > filters.limit()
+	if more {
+		books = books[:filters.limit()]
+	}
+
+	// Fill in the authors and publishers for the whole page with one query each.
+	err = loadRelations(ctx, tx, books...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	if keyset && c.Before {
+		for i, j := 0, len(books)-1; i < j; i, j = i+1, j-1 {
+			books[i], books[j] = books[j], books[i]
+		}
+	}
+
 	// Generate a Metadata struct, passing in the total record count and pagination
 	// parameters from the client.
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	var metadata Metadata
+	switch {
+	case keyset:
+		metadata = Metadata{PageSize: filters.PageSize}
+		if !filters.SkipTotal {
+			err = tx.QueryRowContext(ctx, "SELECT count(*) FROM books"+countWhere, countArgs...).Scan(&metadata.TotalRecords)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+		}
+	case filters.SkipTotal:
+		metadata = Metadata{CurrentPage: filters.Page, PageSize: filters.PageSize, FirstPage: 1}
+	default:
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+
+	// Work out whether there are pages on either side of this one, and if so hand out
+	// cursors pointing at them. A page reached through a forward cursor always has one
+	// before it, and likewise a page reached through a backward cursor has one after it.
+	// Relevance ranks aren't stable enough to page through by key, so we only hand out
+	// cursors for the other sort orders.
+	if len(books) > 0 && column != "relevance" {
+		first, last := books[0], books[len(books)-1]
+		hasNext := more
+		hasPrev := !keyset && filters.Page > 1
+		if keyset {
+			hasNext = more || c.Before
+			hasPrev = !c.Before || more
+		}
+		if hasNext {
+			metadata.NextCursor = cursor{Sort: filters.Sort, Value: last.sortValue(column), ID: last.ID}.encode(filters.CursorKey)
+		}
+		if hasPrev {
+			metadata.PrevCursor = cursor{Sort: filters.Sort, Value: first.sortValue(column), ID: first.ID, Before: true}.encode(filters.CursorKey)
+		}
+	}
+
 	// Include the metadata struct when returning.
 	return books, metadata, nil
 }
+
+// The sortValue() method returns the value of the given sort column for the book, in
+// the form stored in cursors.
+func (book *Book) sortValue(column string) string {
+	switch column {
+	case "title":
+		return book.Title
+	case "year":
+		return strconv.FormatInt(int64(book.Year), 10)
+	case "price":
+		return strconv.FormatUint(book.Price, 10)
+	default:
+		return strconv.FormatInt(book.ID, 10)
+	}
+}
+
+// The bookSortValue() function converts a sort value taken from a cursor back into the
+// Go type matching the column, ready to be used as a query argument.
+func bookSortValue(column, value string) (any, error) {
+	if column == "title" {
+		return value, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return n, nil
+}
+
+// The resolveAuthors() helper makes sure that book.Authors holds the book's authors,
+// and that book.Author holds the matching display string. If the book was given no
+// Authors, the Author string is taken as the name of a single author, who is looked up
+// (or created) by name.
+func resolveAuthors(ctx context.Context, q querier, book *Book) error {
+	if len(book.Authors) == 0 {
+		author, err := findOrCreateAuthor(ctx, q, book.Author)
+		if err != nil {
+			return err
+		}
+		book.Authors = []*Author{author}
+	}
+
+	names := make([]string, len(book.Authors))
+	for i, author := range book.Authors {
+		names[i] = author.Name
+	}
+	book.Author = strings.Join(names, ", ")
+	return nil
+}
+
+// The linkAuthors() helper replaces the rows in book_authors for the book with its
+// current Authors, in order.
+func linkAuthors(ctx context.Context, q querier, book *Book) error {
+	_, err := q.ExecContext(ctx, `DELETE FROM book_authors WHERE book_id = $1`, book.ID)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int64, len(book.Authors))
+	for i, author := range book.Authors {
+		ids[i] = author.ID
+	}
+
+	query := `
+		INSERT INTO book_authors (book_id, author_id, position)
+		SELECT $1::bigint, author_id, position - 1
+		FROM unnest($2::bigint[]) WITH ORDINALITY AS a(author_id, position)`
+	_, err = q.ExecContext(ctx, query, book.ID, ids)
+	if err != nil {
+		return translateError(err)
+	}
+	return nil
+}
+
+// The loadRelations() helper fills in the Authors, GenreNames and Publisher of each of
+// the books, using one query for all the authors, one for all the genres and one for
+// all the publishers.
+func loadRelations(ctx context.Context, q querier, books ...*Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*Book, len(books))
+	bookIDs := make([]int64, 0, len(books))
+	publisherIDs := []int64{}
+	for _, book := range books {
+		book.Authors = []*Author{}
+		book.Publisher = nil
+		byID[book.ID] = book
+		bookIDs = append(bookIDs, book.ID)
+		if book.PublisherID != nil {
+			publisherIDs = append(publisherIDs, *book.PublisherID)
+		}
+	}
+
+	query := `
+		SELECT book_authors.book_id, authors.id, authors.created_at, authors.name, authors.bio, authors.version
+		FROM book_authors
+		INNER JOIN authors ON authors.id = book_authors.author_id
+		WHERE book_authors.book_id = ANY($1)
+		ORDER BY book_authors.book_id, book_authors.position`
+	rows, err := q.QueryContext(ctx, query, bookIDs)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID int64
+		var author Author
+		err := rows.Scan(&bookID, &author.ID, &author.CreatedAt, &author.Name, &author.Bio, &author.Version)
+		if err != nil {
+			return err
+		}
+		byID[bookID].Authors = append(byID[bookID].Authors, &author)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	err = loadGenreNames(ctx, q, books)
+	if err != nil {
+		return err
+	}
+
+	if len(publisherIDs) == 0 {
+		return nil
+	}
+
+	query = `
+		SELECT id, created_at, name, website, version
+		FROM publishers
+		WHERE id = ANY($1)`
+	rows, err = q.QueryContext(ctx, query, publisherIDs)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	publishers := make(map[int64]*Publisher)
+	for rows.Next() {
+		var publisher Publisher
+		err := rows.Scan(&publisher.ID, &publisher.CreatedAt, &publisher.Name, &publisher.Website, &publisher.Version)
+		if err != nil {
+			return err
+		}
+		publishers[publisher.ID] = &publisher
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, book := range books {
+		if book.PublisherID != nil {
+			book.Publisher = publishers[*book.PublisherID]
+		}
+	}
+	return nil
+}
+
+// The loadGenreNames() helper sets the GenreNames of each of the books to the names of
+// its genres, in the same order as their slugs. A slug which isn't in the taxonomy
+// (which shouldn't happen) is used as its own name.
+func loadGenreNames(ctx context.Context, q querier, books []*Book) error {
+	slugs := []string{}
+	for _, book := range books {
+		slugs = append(slugs, book.Genres...)
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT slug, name FROM genres WHERE slug = ANY($1)`, slugs)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	names := make(map[string]string)
+	for rows.Next() {
+		var slug, name string
+		err := rows.Scan(&slug, &name)
+		if err != nil {
+			return err
+		}
+		names[slug] = name
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, book := range books {
+		book.GenreNames = make([]string, len(book.Genres))
+		for i, slug := range book.Genres {
+			book.GenreNames[i] = slug
+			if name, ok := names[slug]; ok {
+				book.GenreNames[i] = name
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"finalProjectAdvancedP/internal/validator"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingDriver is a database/sql driver which records the queries run through it. It
+// answers count queries with a single count, and every other query with no rows.
+type recordingDriver struct {
+	mu      sync.Mutex
+	queries []recordedQuery
+	count   int64
+}
+
+type recordedQuery struct {
+	query string
+	args  []any
+}
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) {
+	return recordingConn{d}, nil
+}
+
+func (d *recordingDriver) recorded() []recordedQuery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]recordedQuery(nil), d.queries...)
+}
+
+type recordingConn struct{ d *recordingDriver }
+
+func (c recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("recording driver: Prepare isn't supported")
+}
+func (c recordingConn) Close() error              { return nil }
+func (c recordingConn) Begin() (driver.Tx, error) { return recordingTx{}, nil }
+func (c recordingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return recordingTx{}, nil
+}
+
+// Any argument is accepted as it is, as the queries are never run.
+func (c recordingConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c recordingConn) record(query string, args []driver.NamedValue) {
+	values := make([]any, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	c.d.mu.Lock()
+	c.d.queries = append(c.d.queries, recordedQuery{query: query, args: values})
+	c.d.mu.Unlock()
+}
+
+func (c recordingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.record(query, args)
+	return driver.RowsAffected(0), nil
+}
+
+func (c recordingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.record(query, args)
+	if strings.HasPrefix(strings.TrimSpace(query), "SELECT count(*) FROM") {
+		return &recordingRows{columns: []string{"count"}, values: [][]driver.Value{{c.d.count}}}, nil
+	}
+	return &recordingRows{}, nil
+}
+
+type recordingTx struct{}
+
+func (recordingTx) Commit() error   { return nil }
+func (recordingTx) Rollback() error { return nil }
+
+type recordingRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *recordingRows) Columns() []string { return r.columns }
+func (r *recordingRows) Close() error      { return nil }
+func (r *recordingRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+var registerRecordingDriver sync.Once
+
+// The openRecording() helper opens a database on the recording driver, answering count
+// queries with 5.
+func openRecording(t *testing.T) (*sql.DB, *recordingDriver) {
+	t.Helper()
+	registerRecordingDriver.Do(func() { sql.Register("recording", &recordingDriver{count: 5}) })
+	db, err := sql.Open("recording", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// sql.Register keeps the driver it was given, so fetch that back for its records.
+	return db, db.Driver().(*recordingDriver)
+}
+
+// A page reached through a cursor must count every book matching the search, not only
+// the ones past the cursor, or the total would shrink from page to page.
+func TestGetAllKeysetCountIgnoresCursor(t *testing.T) {
+	db, d := openRecording(t)
+
+	key := []byte("cursor secret")
+	search := BookSearch{Title: "dune", MinYear: 1960}
+	filters := Filters{
+		Page:         1,
+		PageSize:     2,
+		Sort:         "year",
+		SortSafelist: []string{"year"},
+		Cursor:       cursor{Sort: "year", Value: "1965", ID: 3}.encode(key),
+		CursorKey:    key,
+	}
+
+	_, metadata, err := BookModel{}.getAll(db, search, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.TotalRecords != 5 {
+		t.Errorf("TotalRecords = %d; want 5", metadata.TotalRecords)
+	}
+
+	wantWhere, wantArgs := search.where()
+	var counted bool
+	for _, q := range d.recorded() {
+		if !strings.HasPrefix(q.query, "SELECT count(*)") {
+			continue
+		}
+		counted = true
+		if q.query != "SELECT count(*) FROM books"+wantWhere {
+			t.Errorf("count query has conditions beyond the search:\n%s", q.query)
+		}
+		if !reflect.DeepEqual(q.args, wantArgs) {
+			t.Errorf("count query args = %v; want %v", q.args, wantArgs)
+		}
+	}
+	if !counted {
+		t.Error("no count query was run")
+	}
+}
+
+// Fuzzy matches must go through the <% operator, which the trigram indexes can serve,
+// with the threshold set for the transaction the search runs in.
+func TestGetAllFuzzyThreshold(t *testing.T) {
+	db, d := openRecording(t)
+
+	search := BookSearch{Query: "dostoyevsky", Fuzzy: true, Similarity: 0.35}
+	filters := Filters{Page: 1, PageSize: 20, Sort: "relevance", SortSafelist: []string{"relevance"}}
+	_, _, err := BookModel{}.getAll(db, search, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Find the threshold being set, followed by the listing itself.
+	queries := d.recorded()
+	threshold := -1
+	for i, q := range queries {
+		if strings.Contains(q.query, "pg_trgm.word_similarity_threshold") {
+			threshold = i
+		}
+	}
+	if threshold < 0 || threshold == len(queries)-1 {
+		t.Fatal("the threshold wasn't set before the search")
+	}
+	if args := queries[threshold].args; !reflect.DeepEqual(args, []any{"0.35"}) {
+		t.Errorf("threshold set to %v; want 0.35", args)
+	}
+
+	list := queries[threshold+1].query
+	where := list[strings.Index(list, "WHERE"):strings.Index(list, "ORDER BY")]
+	if strings.Contains(where, "word_similarity(") || !strings.Contains(where, "<% title") {
+		t.Errorf("fuzzy search doesn't filter with the <%% operator:\n%s", where)
+	}
+}
+
+func TestValidateBookSearchSimilarity(t *testing.T) {
+	tests := []struct {
+		similarity float64
+		valid      bool
+	}{
+		{0, true},
+		{0.4, true},
+		{1, true},
+		{-0.1, false},
+		{1.5, false},
+		{math.NaN(), false},
+	}
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateBookSearch(v, BookSearch{Fuzzy: true, Similarity: tt.similarity})
+		if v.Valid() != tt.valid {
+			t.Errorf("similarity %v: valid = %v; want %v (errors %v)", tt.similarity, v.Valid(), tt.valid, v.Errors)
+		}
+	}
+}
+
+func TestValidateBookAuthors(t *testing.T) {
+	tests := []struct {
+		name  string
+		book  Book
+		field string
+	}{
+		{"name", Book{Author: "Frank Herbert"}, ""},
+		{"padded name", Book{Author: "  Frank Herbert  "}, ""},
+		{"no name", Book{}, "author"},
+		{"blank name", Book{Author: " \t "}, "author"},
+		{"authors", Book{Authors: []*Author{{ID: 1, Name: "Frank Herbert"}}}, ""},
+		{"blank author", Book{Authors: []*Author{{Name: "Frank Herbert"}, {Name: "  "}}}, "authors"},
+	}
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateBook(v, &tt.book, nil)
+		for _, field := range []string{"author", "authors"} {
+			_, got := v.Errors[field]
+			if want := field == tt.field; got != want {
+				t.Errorf("%s: error for %s = %v; want %v (errors %v)", tt.name, field, got, want, v.Errors)
+			}
+		}
+	}
+}
+
+// The TestGetAllKeysetTotal test walks a listing page by page with cursors against the
+// database in BOOKSTORE_TEST_DSN (see bench_test.go), and is skipped when that isn't
+// set. The database must have the migrations applied.
+func TestGetAllKeysetTotal(t *testing.T) {
+	dsn := os.Getenv("BOOKSTORE_TEST_DSN")
+	if dsn == "" {
+		t.Skip("BOOKSTORE_TEST_DSN not set")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	books := BookModel{DB: db, Replicas: NewReplicas(db)}
+	author := fmt.Sprintf("Keyset Walker %d", time.Now().UnixNano())
+	for i := 1; i <= 5; i++ {
+		book := &Book{Title: fmt.Sprintf("Keyset Test %d", i), Author: author, Year: int32(1990 + i), Genres: []string{"fiction"}, Price: 100}
+		err := books.Insert(book, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			db.Exec(`DELETE FROM book_authors WHERE book_id = $1`, book.ID)
+			db.Exec(`DELETE FROM books WHERE id = $1`, book.ID)
+			db.Exec(`DELETE FROM book_revisions WHERE book_id = $1`, book.ID)
+		})
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM authors WHERE name = $1`, author) })
+
+	search := BookSearch{Author: author}
+	filters := Filters{Page: 1, PageSize: 2, Sort: "id", SortSafelist: []string{"id"}, CursorKey: []byte("secret")}
+
+	var totals []int
+	for {
+		page, metadata, err := books.GetAll(search, filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			t.Fatal("got an empty page")
+		}
+		totals = append(totals, metadata.TotalRecords)
+		if metadata.NextCursor == "" {
+			break
+		}
+		filters.Cursor = metadata.NextCursor
+	}
+
+	if want := []int{5, 5, 5}; !reflect.DeepEqual(totals, want) {
+		t.Errorf("total_records per page = %v; want %v", totals, want)
+	}
+}
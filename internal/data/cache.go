@@ -0,0 +1,217 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"finalProjectAdvancedP/internal/cache"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// cacheGenerationKey holds the current generation of the book cache. Every cached
+// result is stored under the generation it was read in, so starting a new generation
+// drops the whole cache at once.
+const cacheGenerationKey = "books:generation"
+
+// MaxReplicaLag is how far behind the primary a read replica may fall before it is
+// taken out of rotation (see Replicas.MonitorHealth()). For that long after a change
+// to the catalog, results which miss the cache are read from the primary, as a replica
+// may not have applied the change yet and its old result would be cached for the whole
+// TTL. After that, misses are read through the replicas like any other read.
+const MaxReplicaLag = 10 * time.Second
+
+// A BookCache caches the results of BookModel.Get() and BookModel.GetAll(). Books embed
+// their authors and publisher, and one change can touch many books (renaming a genre,
+// say), so rather than tracking which entries a change affects, any change to the
+// catalog invalidates all of them. The catalog is read far more often than it changes,
+// so this costs little. A nil *BookCache caches nothing.
+type BookCache struct {
+	cache cache.Cache
+	ttl   time.Duration
+
+	hits     atomic.Int64
+	misses   atomic.Int64
+	failures atomic.Int64
+}
+
+// NewBookCache returns a BookCache which keeps results in c for up to ttl.
+func NewBookCache(c cache.Cache, ttl time.Duration) *BookCache {
+	return &BookCache{cache: c, ttl: ttl}
+}
+
+// The Stats() method returns the number of cache hits and misses so far, and the number
+// of times the cache couldn't be reached. Those count as misses too.
+func (c *BookCache) Stats() map[string]int64 {
+	if c == nil {
+		return map[string]int64{}
+	}
+	return map[string]int64{
+		"hits":   c.hits.Load(),
+		"misses": c.misses.Load(),
+		"errors": c.failures.Load(),
+	}
+}
+
+// The generation() method returns the current generation and when it started, starting
+// a new one if there isn't one. The generation must be read before the database is, so
+// that a result read while a change is being made is stored under the old generation,
+// and never served.
+func (c *BookCache) generation(ctx context.Context) (string, time.Time, error) {
+	gen, err := c.cache.Get(ctx, cacheGenerationKey)
+	if err == nil {
+		return string(gen), generationStart(string(gen)), nil
+	}
+	if !errors.Is(err, cache.ErrMiss) {
+		return "", time.Time{}, err
+	}
+	return c.newGeneration(ctx)
+}
+
+// The newGeneration() method starts a new generation, made of a random part and the
+// time it started. The generation key outlives the entries by a good margin; if it goes
+// missing anyway, the worst that happens is that the cache starts afresh.
+func (c *BookCache) newGeneration(ctx context.Context) (string, time.Time, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	started := time.Now()
+	gen := hex.EncodeToString(b) + "-" + strconv.FormatInt(started.UnixNano(), 10)
+	return gen, started, c.cache.Set(ctx, cacheGenerationKey, []byte(gen), 24*time.Hour+c.ttl)
+}
+
+// The generationStart() function returns when a generation started. A generation it
+// can't read is taken to have started long ago.
+func generationStart(gen string) time.Time {
+	_, nanos, _ := strings.Cut(gen, "-")
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// A cacheFill says where a result which missed the cache is to be stored: under key,
+// in the generation which started at started. The key is "" if nothing should be
+// stored, because there is no cache or it couldn't be reached.
+type cacheFill struct {
+	key     string
+	started time.Time
+}
+
+// The lookup() method decodes the cached result stored under name into dst. It returns
+// where to store the result on a miss, and whether it was a hit.
+func (c *BookCache) lookup(name string, dst any) (cacheFill, bool) {
+	if c == nil {
+		return cacheFill{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	gen, started, err := c.generation(ctx)
+	if err != nil {
+		c.failures.Add(1)
+		c.misses.Add(1)
+		return cacheFill{}, false
+	}
+	fill := cacheFill{key: "books:" + gen + ":" + name, started: started}
+
+	value, err := c.cache.Get(ctx, fill.key)
+	if err != nil {
+		if !errors.Is(err, cache.ErrMiss) {
+			c.failures.Add(1)
+		}
+		c.misses.Add(1)
+		return fill, false
+	}
+	err = gob.NewDecoder(bytes.NewReader(value)).Decode(dst)
+	if err != nil {
+		c.failures.Add(1)
+		c.misses.Add(1)
+		return fill, false
+	}
+	c.hits.Add(1)
+	return fill, true
+}
+
+// The store() method caches a result where lookup() said to.
+func (c *BookCache) store(fill cacheFill, value any) {
+	if c == nil || fill.key == "" {
+		return
+	}
+
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(value)
+	if err != nil {
+		c.failures.Add(1)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err = c.cache.Set(ctx, fill.key, buf.Bytes(), c.ttl)
+	if err != nil {
+		c.failures.Add(1)
+	}
+}
+
+// The invalidate() method drops every cached result, by starting a new generation. It
+// should be called once a change to the catalog has been committed.
+func (c *BookCache) invalidate() {
+	if c == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, _, err := c.newGeneration(ctx)
+	if err != nil {
+		c.failures.Add(1)
+	}
+}
+
+// A cachedBookList is a page of books as kept in the cache.
+type cachedBookList struct {
+	Books    []*Book
+	Metadata Metadata
+}
+
+// The bookListCacheKey() function returns the cache key for a GetAll() query. Genres
+// are sorted first, as their order doesn't change the result. The cursor key is part of
+// it, as the cursors in the metadata are signed with it.
+func bookListCacheKey(search BookSearch, filters Filters) string {
+	genres := append([]string(nil), search.Genres...)
+	sort.Strings(genres)
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%q %q %q %q %d %d %d %d %t %g %t\n",
+		search.Title, search.Author, search.Query, genres, search.MinPrice, search.MaxPrice,
+		search.MinYear, search.MaxYear, search.Fuzzy, search.Similarity, search.IncludeDeleted)
+	fmt.Fprintf(hash, "%d %d %q %q %t %x\n",
+		filters.Page, filters.PageSize, filters.Sort, filters.Cursor, filters.SkipTotal, filters.CursorKey)
+	return "list:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// The commit() method commits a transaction which changed the catalog, and drops the
+// cached results once it has.
+func (c *BookCache) commit(tx *sql.Tx) error {
+	err := tx.Commit()
+	if err == nil {
+		c.invalidate()
+	}
+	return err
+}
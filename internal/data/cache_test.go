@@ -0,0 +1,147 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"finalProjectAdvancedP/internal/cache"
+	"testing"
+	"time"
+)
+
+func TestBookCacheInvalidate(t *testing.T) {
+	c := NewBookCache(cache.NewLRU(100), time.Minute)
+
+	var book *Book
+	fill, hit := c.lookup("book:1", &book)
+	if hit || fill.key == "" {
+		t.Fatalf("lookup() on an empty cache = %q, %v; want a miss with a key", fill.key, hit)
+	}
+	if time.Since(fill.started) > time.Minute {
+		t.Errorf("new generation started at %v; want now", fill.started)
+	}
+	c.store(fill, &Book{ID: 1, Title: "Dune", Version: 1})
+
+	fill, hit = c.lookup("book:1", &book)
+	if !hit || book.Title != "Dune" {
+		t.Fatalf("lookup() after store() = %v, %+v; want a hit", hit, book)
+	}
+
+	// A result looked up before a change but stored after it lands in the old
+	// generation, so it is never served.
+	staleFill, _ := c.lookup("book:2", &book)
+	c.invalidate()
+	c.store(staleFill, &Book{ID: 2, Title: "Old title"})
+
+	book = nil
+	newFill, hit := c.lookup("book:1", &book)
+	if hit {
+		t.Errorf("lookup() after invalidate() = hit %+v; want a miss", book)
+	}
+	if newFill.key == fill.key {
+		t.Errorf("key %q didn't change with the generation", fill.key)
+	}
+	if _, hit = c.lookup("book:2", &book); hit {
+		t.Errorf("lookup() served %+v, which was stored under the old generation", book)
+	}
+
+	want := map[string]int64{"hits": 1, "misses": 4, "errors": 0}
+	for name, n := range c.Stats() {
+		if n != want[name] {
+			t.Errorf("Stats()[%s] = %d; want %d", name, n, want[name])
+		}
+	}
+}
+
+// failingCache is a cache which can't be reached.
+type failingCache struct{}
+
+func (failingCache) Get(ctx context.Context, key string) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (failingCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return errors.New("connection refused")
+}
+
+func (failingCache) Delete(ctx context.Context, key string) error {
+	return errors.New("connection refused")
+}
+
+func TestBookCacheUnreachable(t *testing.T) {
+	c := NewBookCache(failingCache{}, time.Minute)
+
+	var book *Book
+	fill, hit := c.lookup("book:1", &book)
+	if hit || fill.key != "" {
+		t.Errorf("lookup() = %q, %v; want a miss with no key", fill.key, hit)
+	}
+	c.invalidate()
+	if got := c.Stats()["errors"]; got != 2 {
+		t.Errorf("Stats()[errors] = %d; want 2", got)
+	}
+
+	// A nil cache is off: it misses every time, without a key to store under.
+	var off *BookCache
+	if fill, hit := off.lookup("book:1", &book); hit || fill.key != "" {
+		t.Errorf("nil lookup() = %q, %v; want a miss with no key", fill.key, hit)
+	}
+	off.store(cacheFill{key: "key"}, &Book{})
+	off.invalidate()
+}
+
+func TestGenerationStart(t *testing.T) {
+	started := time.Unix(1700000000, 123)
+	tests := []struct {
+		gen  string
+		want time.Time
+	}{
+		{"0123456789abcdef-1700000000000000123", started},
+		{"0123456789abcdef", time.Time{}},
+		{"0123456789abcdef-soon", time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := generationStart(tt.gen); !got.Equal(tt.want) {
+			t.Errorf("generationStart(%q) = %v; want %v", tt.gen, got, tt.want)
+		}
+	}
+}
+
+// Misses go to a replica, except for results which are going to be cached in a
+// generation so new that a replica may not have caught up with the change that
+// started it.
+func TestReadUncached(t *testing.T) {
+	// The pools are never connected to, as the reads only look at which one they get.
+	primary, err := sql.Open("pgx", "postgres://primary.invalid/bookstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	replica, err := sql.Open("pgx", "postgres://replica.invalid/bookstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+
+	m := BookModel{DB: primary, Replicas: NewReplicas(primary, replica)}
+
+	tests := []struct {
+		name string
+		fill cacheFill
+		want *sql.DB
+	}{
+		{"new generation", cacheFill{key: "books:gen:book:1", started: time.Now()}, primary},
+		{"settled generation", cacheFill{key: "books:gen:book:1", started: time.Now().Add(-2 * MaxReplicaLag)}, replica},
+		{"not cached", cacheFill{}, replica},
+	}
+	for _, tt := range tests {
+		var got *sql.DB
+		m.readUncached(tt.fill, func(db *sql.DB) error {
+			got = db
+			return nil
+		})
+		if got != tt.want {
+			t.Errorf("readUncached() with a %s read from the wrong pool", tt.name)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"database/sql"
 	//"finalProjectAdvancedP/internal/validator"
-	"github.com/lib/pq"
 	"time"
 )
 
@@ -27,11 +26,14 @@ func (m CartModel) Insert(cart *Cart) error {
 	query := `
       INSERT INTO carts (email ,book_id, books, quantity, total_quantity, total_price)
       VALUES ($1, $2, $3, $4, $5, $6)`
-	args := []any{cart.Email, cart.BookId, pq.Array(cart.Books), cart.Quantity, cart.TotalQuantity, cart.TotalPrice}
+	args := []any{cart.Email, cart.BookId, cart.Books, cart.Quantity, cart.TotalQuantity, cart.TotalPrice}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
-	return err
+	if err != nil {
+		return translateError(err)
+	}
+	return nil
 }
 
 func (m CartModel) Delete(cart *Cart) error {
@@ -68,7 +70,8 @@ func (m CartModel) Delete(cart *Cart) error {
 func (m CartModel) GetAll() ([]*Book, error) {
 
 	query := `
-		SELECT * FROM books
+		SELECT ` + bookColumns + `
+		FROM books
 		WHERE id IN (SELECT book_id FROM carts)
 		ORDER BY title`
 
@@ -83,16 +86,7 @@ func (m CartModel) GetAll() ([]*Book, error) {
 	books := []*Book{}
 	for rows.Next() {
 		var book Book
-		err := rows.Scan(
-			&book.ID,
-			&book.CreatedAt,
-			&book.Title,
-			&book.Year,
-			&book.Author,
-			pq.Array(&book.Genres),
-			&book.Price,
-			&book.Version,
-		)
+		err := rows.Scan(book.scanTargets()...)
 		if err != nil {
 			return nil, err // Update this to return an empty Metadata struct.
 		}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Define the typed errors for the integrity constraint violations that PostgreSQL can
+// report back to us. A *ConstraintError always unwraps to exactly one of these, so the
+// handlers can use errors.Is() to decide which kind of violation happened.
+var (
+	ErrUniqueViolation     = errors.New("unique constraint violation")
+	ErrForeignKeyViolation = errors.New("foreign key constraint violation")
+	ErrCheckViolation      = errors.New("check constraint violation")
+	ErrNotNullViolation    = errors.New("not null constraint violation")
+)
+
+// The ConstraintError type holds the details of a constraint violation in a form that
+// can be returned to the client: the name of the field which caused it and a message
+// which explains what went wrong.
+type ConstraintError struct {
+	Kind       error
+	Constraint string
+	Field      string
+	Message    string
+	sentinel   error
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("%s: %s %s", e.Kind, e.Field, e.Message)
+}
+
+// Unwrap returns the kind of the violation, so that errors.Is(err, ErrUniqueViolation)
+// and friends work on a wrapped *ConstraintError.
+func (e *ConstraintError) Unwrap() error {
+	return e.Kind
+}
+
+// Is makes a *ConstraintError also match the older, more specific sentinel errors (such
+// as ErrDuplicateEmail) which some callers still check for.
+func (e *ConstraintError) Is(target error) bool {
+	return e.sentinel != nil && target == e.sentinel
+}
+
+// constraintDetail describes how a named constraint is reported to the client.
+type constraintDetail struct {
+	field    string
+	message  string
+	sentinel error
+}
+
+// The constraints map holds the field name and client-facing message for each named
+// constraint in our migrations. Whenever a new constraint is added to the schema, it
+// should be added here too.
+var constraints = map[string]constraintDetail{
+	"users_email_key":             {field: "email", message: "a user with this email address already exists", sentinel: ErrDuplicateEmail},
+	"books_price_check":           {field: "price", message: "must be greater than zero"},
+	"books_year_check":            {field: "year", message: "must be between 1455 and the current year"},
+	"genres_length_check":         {field: "genres", message: "must contain between 1 and 5 genres"},
+	"carts_book_id_fkey":          {field: "book_id", message: "must refer to an existing book"},
+	"authors_name_key":            {field: "name", message: "an author with this name already exists"},
+	"publishers_name_key":         {field: "name", message: "a publisher with this name already exists"},
+	"book_authors_author_id_fkey": {field: "author_ids", message: "must refer to existing authors"},
+	"books_publisher_id_fkey":     {field: "publisher_id", message: "must refer to an existing publisher"},
+	"books_isbn10_key":            {field: "isbn10", message: "a book with this ISBN already exists"},
+	"books_isbn13_key":            {field: "isbn13", message: "a book with this ISBN already exists"},
+	"genres_slug_key":             {field: "slug", message: "a genre with this slug already exists"},
+	"genres_parent_id_fkey":       {field: "parent_id", message: "must refer to an existing genre"},
+}
+
+// The translateError() helper inspects an error returned by the database driver and,
+// if it is an integrity constraint violation, converts it into a *ConstraintError.
+// Any other error is returned unchanged.
+func translateError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	var kind error
+	switch pgErr.Code {
+	case "23505":
+		kind = ErrUniqueViolation
+	case "23503":
+		kind = ErrForeignKeyViolation
+	case "23514":
+		kind = ErrCheckViolation
+	case "23502":
+		kind = ErrNotNullViolation
+	default:
+		return err
+	}
+
+	cErr := &ConstraintError{
+		Kind:       kind,
+		Constraint: pgErr.ConstraintName,
+	}
+
+	// If we know about the constraint, use its field and message. Otherwise fall back
+	// to the column reported by PostgreSQL (which is always set for not-null
+	// violations) and a generic message for the kind of violation.
+	if detail, ok := constraints[pgErr.ConstraintName]; ok {
+		cErr.Field = detail.field
+		cErr.Message = detail.message
+		cErr.sentinel = detail.sentinel
+		return cErr
+	}
+
+	cErr.Field = pgErr.ColumnName
+	if cErr.Field == "" {
+		cErr.Field = pgErr.ConstraintName
+	}
+
+	switch kind {
+	case ErrUniqueViolation:
+		cErr.Message = "a record with this value already exists"
+	case ErrForeignKeyViolation:
+		cErr.Message = "must refer to an existing record"
+	case ErrCheckViolation:
+		cErr.Message = "contains an invalid value"
+	case ErrNotNullViolation:
+		cErr.Message = "must be provided"
+	}
+	return cErr
+}
@@ -0,0 +1,81 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestTranslateError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		kind     error // nil if err should come back unchanged
+		field    string
+		message  string
+		sentinel error
+	}{
+		// Unique violations on each of the known constraints.
+		{"users_email_key", &pgconn.PgError{Code: "23505", ConstraintName: "users_email_key"},
+			ErrUniqueViolation, "email", "a user with this email address already exists", ErrDuplicateEmail},
+		{"authors_name_key", &pgconn.PgError{Code: "23505", ConstraintName: "authors_name_key"},
+			ErrUniqueViolation, "name", "an author with this name already exists", nil},
+		{"publishers_name_key", &pgconn.PgError{Code: "23505", ConstraintName: "publishers_name_key"},
+			ErrUniqueViolation, "name", "a publisher with this name already exists", nil},
+		{"books_isbn10_key", &pgconn.PgError{Code: "23505", ConstraintName: "books_isbn10_key"},
+			ErrUniqueViolation, "isbn10", "a book with this ISBN already exists", nil},
+		{"books_isbn13_key", &pgconn.PgError{Code: "23505", ConstraintName: "books_isbn13_key"},
+			ErrUniqueViolation, "isbn13", "a book with this ISBN already exists", nil},
+		{"genres_slug_key", &pgconn.PgError{Code: "23505", ConstraintName: "genres_slug_key"},
+			ErrUniqueViolation, "slug", "a genre with this slug already exists", nil},
+		{"unknown unique constraint", &pgconn.PgError{Code: "23505", ConstraintName: "books_slug_key"},
+			ErrUniqueViolation, "books_slug_key", "a record with this value already exists", nil},
+
+		{"foreign key", &pgconn.PgError{Code: "23503", ConstraintName: "book_authors_author_id_fkey"},
+			ErrForeignKeyViolation, "author_ids", "must refer to existing authors", nil},
+		{"unknown foreign key", &pgconn.PgError{Code: "23503", ConstraintName: "reviews_book_id_fkey"},
+			ErrForeignKeyViolation, "reviews_book_id_fkey", "must refer to an existing record", nil},
+		{"check", &pgconn.PgError{Code: "23514", ConstraintName: "books_price_check"},
+			ErrCheckViolation, "price", "must be greater than zero", nil},
+		{"unknown check", &pgconn.PgError{Code: "23514", ConstraintName: "books_pages_check"},
+			ErrCheckViolation, "books_pages_check", "contains an invalid value", nil},
+		{"not null", &pgconn.PgError{Code: "23502", ColumnName: "title"},
+			ErrNotNullViolation, "title", "must be provided", nil},
+		{"wrapped", fmt.Errorf("insert book: %w", &pgconn.PgError{Code: "23505", ConstraintName: "books_isbn13_key"}),
+			ErrUniqueViolation, "isbn13", "a book with this ISBN already exists", nil},
+
+		{"unknown code", &pgconn.PgError{Code: "40001"}, nil, "", "", nil},
+		{"not a database error", sql.ErrNoRows, nil, "", "", nil},
+	}
+
+	for _, tt := range tests {
+		got := translateError(tt.err)
+		if tt.kind == nil {
+			if got != tt.err {
+				t.Errorf("%s: translateError() = %v; want the error unchanged", tt.name, got)
+			}
+			continue
+		}
+
+		var cErr *ConstraintError
+		if !errors.As(got, &cErr) {
+			t.Errorf("%s: translateError() = %v; want a *ConstraintError", tt.name, got)
+			continue
+		}
+		if !errors.Is(got, tt.kind) {
+			t.Errorf("%s: translateError() kind = %v; want %v", tt.name, cErr.Kind, tt.kind)
+		}
+		if cErr.Field != tt.field || cErr.Message != tt.message {
+			t.Errorf("%s: translateError() = %q %q; want %q %q", tt.name, cErr.Field, cErr.Message, tt.field, tt.message)
+		}
+		if tt.sentinel != nil && !errors.Is(got, tt.sentinel) {
+			t.Errorf("%s: translateError() doesn't match %v", tt.name, tt.sentinel)
+		}
+		if tt.sentinel == nil && errors.Is(got, ErrDuplicateEmail) {
+			t.Errorf("%s: translateError() matches ErrDuplicateEmail", tt.name)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// A cursor marks a position in a sorted listing: the value of the sort column and the
+// id of the record at that position. Before is set on cursors which point at the page
+// preceding the position, rather than the page following it.
+type cursor struct {
+	Sort   string `json:"s"`
+	Value  string `json:"v"`
+	ID     int64  `json:"i"`
+	Before bool   `json:"b,omitempty"`
+}
+
+// The encode() method returns the cursor as an opaque string which is safe to use in a
+// URL. The JSON-encoded cursor is signed with an HMAC-SHA256 of key, so that clients
+// can't forge or tamper with cursors to inject arbitrary values into our queries.
+func (c cursor) encode(key []byte) string {
+	js, err := json.Marshal(c)
+	if err != nil {
+		// Marshalling a struct of strings, ints and bools can't fail.
+		panic(err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(js)
+
+	return base64.RawURLEncoding.EncodeToString(js) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// The decodeCursor() function verifies the signature on an opaque cursor string and
+// returns the cursor it contains. It returns ErrInvalidCursor if the string is
+// malformed or the signature doesn't match.
+func decodeCursor(key []byte, s string) (cursor, error) {
+	payload, signature, ok := strings.Cut(s, ".")
+	if !ok {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(js)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	var c cursor
+	err = json.Unmarshal(js, &c)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
@@ -0,0 +1,72 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	key := []byte("cursor secret")
+
+	for _, c := range []cursor{
+		{Sort: "id", Value: "42", ID: 42},
+		{Sort: "-title", Value: "Émile, or On Education", ID: 7, Before: true},
+		{Sort: "price", Value: "", ID: 1},
+	} {
+		s := c.encode(key)
+		if strings.ContainsAny(s, "+/=") {
+			t.Errorf("cursor %q isn't URL safe", s)
+		}
+		got, err := decodeCursor(key, s)
+		if err != nil || got != c {
+			t.Errorf("decodeCursor(encode(%+v)) = %+v, %v", c, got, err)
+		}
+	}
+}
+
+func TestDecodeCursorRejectsTampering(t *testing.T) {
+	key := []byte("cursor secret")
+	valid := cursor{Sort: "id", Value: "42", ID: 42}.encode(key)
+	payload, signature, _ := strings.Cut(valid, ".")
+
+	// A cursor for a different position, signed with the wrong key.
+	forged := cursor{Sort: "id", Value: "1 OR 1=1", ID: 1}.encode([]byte("guessed secret"))
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	// A payload which isn't JSON, signed with the right key.
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("not json"))
+	notJSON := base64.RawURLEncoding.EncodeToString([]byte("not json")) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name string
+		s    string
+	}{
+		{"empty", ""},
+		{"no signature", payload},
+		{"wrong key", forged},
+		{"swapped payload", forgedPayload + "." + signature},
+		{"truncated signature", payload + "." + signature[:len(signature)-2]},
+		{"bad base64 payload", "!!!." + signature},
+		{"bad base64 signature", payload + ".!!!"},
+		{"not json", notJSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeCursor(key, tt.s)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeCursor(%q) = %v; want ErrInvalidCursor", tt.s, err)
+			}
+		})
+	}
+
+	// The same cursor doesn't verify under another key, as when the secret changes.
+	if _, err := decodeCursor([]byte("new secret"), valid); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("decodeCursor() with a new key = %v; want ErrInvalidCursor", err)
+	}
+}
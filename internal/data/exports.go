@@ -0,0 +1,84 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// exportBatchSize is the number of books fetched from the export cursor at a time.
+const exportBatchSize = 500
+
+// The Export() method calls fn for every book matching search, in the order given by
+// filters, without loading the whole catalog into memory. The books are read through a
+// server-side cursor in a read-only transaction, so the export is a consistent snapshot
+// of the catalog even if it changes while the export is running. Only the filters' sort
+// is used; there is no paging.
+func (m BookModel) Export(ctx context.Context, search BookSearch, filters Filters, fn func(book *Book) error) error {
+	db := m.Replicas.pick()
+
+	tx, err := search.beginSearch(ctx, db, sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	where, args := search.where()
+	order := fmt.Sprintf("%s %s, id ASC", filters.sortColumn(), filters.sortDirection())
+	if filters.sortColumn() == "relevance" {
+		order = search.rank(len(args)) + " DESC, id ASC"
+	}
+
+	query := `
+DECLARE export_books NO SCROLL CURSOR FOR
+SELECT ` + bookColumns + `
+FROM books` + where + `
+ORDER BY ` + order
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	fetch := fmt.Sprintf("FETCH %d FROM export_books", exportBatchSize)
+	for {
+		books, err := fetchBooks(ctx, tx, fetch)
+		if err != nil {
+			return err
+		}
+		if len(books) == 0 {
+			return nil
+		}
+
+		err = loadRelations(ctx, tx, books...)
+		if err != nil {
+			return err
+		}
+		for _, book := range books {
+			err = fn(book)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// The fetchBooks() helper runs a query returning the columns in bookColumns, and scans
+// the books it returns.
+func fetchBooks(ctx context.Context, q querier, query string, args ...any) ([]*Book, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := []*Book{}
+	for rows.Next() {
+		var book Book
+		err := rows.Scan(book.scanTargets()...)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, &book)
+	}
+	return books, rows.Err()
+}
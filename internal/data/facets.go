@@ -0,0 +1,159 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"finalProjectAdvancedP/internal/validator"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// FacetSafelist holds the facets which can be requested alongside a book listing.
+var FacetSafelist = []string{"genres", "year", "price"}
+
+// priceBuckets holds the lower bounds of the price ranges used for the price facet. The
+// last bucket has no upper bound.
+var priceBuckets = []int64{0, 1000, 2500, 5000, 10000, 25000}
+
+// The Facets struct holds the number of books matching a search for each value of the
+// requested facets. Facets which weren't requested are left out of the JSON.
+type Facets struct {
+	Genres []FacetCount `json:"genres,omitempty"`
+	Year   []RangeCount `json:"year,omitempty"`
+	Price  []RangeCount `json:"price,omitempty"`
+}
+
+// A FacetCount is the number of matching books with a particular value. For genres the
+// value is the slug, which can be used to filter by, and Name is the display name.
+type FacetCount struct {
+	Value string `json:"value"`
+	Name  string `json:"name,omitempty"`
+	Count int    `json:"count"`
+}
+
+// A RangeCount is the number of matching books with a value between From and To
+// (inclusive). To is left out for the last, open-ended range.
+type RangeCount struct {
+	From  int64 `json:"from"`
+	To    int64 `json:"to,omitempty"`
+	Count int   `json:"count"`
+}
+
+func ValidateFacets(v *validator.Validator, facets []string) {
+	for _, facet := range facets {
+		v.Check(validator.PermittedValue(facet, FacetSafelist...), "facets", "invalid facet value")
+	}
+	v.Check(validator.Unique(facets), "facets", "must not contain duplicate values")
+}
+
+// The Facets() method counts the books matching search for each of the named facets:
+// per genre, per decade of publication and per price range. The counts are computed
+// over the same WHERE clause as GetAll(), so they always agree with the listing.
+func (m BookModel) Facets(search BookSearch, names []string) (*Facets, error) {
+	var facets *Facets
+	err := m.Replicas.read(func(db *sql.DB) error {
+		var err error
+		facets, err = m.facets(db, search, names)
+		return err
+	})
+	return facets, err
+}
+
+func (m BookModel) facets(db *sql.DB, search BookSearch, names []string) (*Facets, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := search.beginSearch(ctx, db, sql.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	where, args := search.where()
+	facets := &Facets{}
+
+	for _, name := range names {
+		switch name {
+		case "genres":
+			query := `
+SELECT g, COALESCE((SELECT name FROM genres WHERE genres.slug = g), g), count(*)
+FROM books, unnest(genres) AS g` + where + `
+GROUP BY g
+ORDER BY count(*) DESC, g ASC`
+			err := queryFacet(ctx, tx, query, args, func(rows *sql.Rows) error {
+				var fc FacetCount
+				err := rows.Scan(&fc.Value, &fc.Name, &fc.Count)
+				facets.Genres = append(facets.Genres, fc)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+
+		case "year":
+			query := `
+SELECT (year / 10) * 10 AS decade, count(*)
+FROM books` + where + `
+GROUP BY decade
+ORDER BY decade ASC`
+			err := queryFacet(ctx, tx, query, args, func(rows *sql.Rows) error {
+				var rc RangeCount
+				err := rows.Scan(&rc.From, &rc.Count)
+				rc.To = rc.From + 9
+				facets.Year = append(facets.Year, rc)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+
+		case "price":
+			// width_bucket() returns the 1-based index of the bucket the price falls
+			// into, given the lower bounds of the buckets.
+			query := fmt.Sprintf(`
+SELECT width_bucket(price, $%d::integer[]) AS bucket, count(*)
+FROM books`+where+`
+GROUP BY bucket
+ORDER BY bucket ASC`, len(args)+1)
+			err := queryFacet(ctx, tx, query, append(args, priceBuckets), func(rows *sql.Rows) error {
+				var bucket, count int
+				err := rows.Scan(&bucket, &count)
+				if err != nil || bucket < 1 {
+					return err
+				}
+				rc := RangeCount{From: priceBuckets[bucket-1], Count: count}
+				if bucket < len(priceBuckets) {
+					rc.To = priceBuckets[bucket] - 1
+				}
+				facets.Price = append(facets.Price, rc)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+
+		default:
+			panic("unsafe facet parameter: " + strconv.Quote(name))
+		}
+	}
+
+	return facets, nil
+}
+
+// The queryFacet() helper runs a facet query and calls scan for each row of the result.
+func queryFacet(ctx context.Context, q querier, query string, args []any, scan func(rows *sql.Rows) error) error {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err = scan(rows)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -12,6 +12,15 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// Cursor holds the opaque cursor sent by the client. When it is set, records are
+	// fetched with keyset pagination starting from the cursor position and Page is
+	// ignored.
+	Cursor string
+	// CursorKey is the secret used to sign and verify cursors.
+	CursorKey []byte
+	// SkipTotal disables counting the total number of matching records, which is
+	// expensive for large result sets.
+	SkipTotal bool
 }
 
 // Check that the client-provided Sort field matches one of the entries in our safelist
@@ -43,6 +52,51 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 	// Check that the sort parameter matches a value in the safelist.
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	// If a cursor was provided, check that it is one we signed and that it was issued
+	// for the same sort order as the one requested.
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.CursorKey, f.Cursor)
+		v.Check(err == nil, "cursor", "must be a valid cursor")
+		if err == nil {
+			v.Check(c.Sort == f.Sort, "cursor", "does not match the sort value")
+		}
+	}
+}
+
+// The keyset() method returns the decoded cursor and true if the client asked for
+// keyset pagination. The cursor must already have been checked by ValidateFilters().
+func (f Filters) keyset() (cursor, bool) {
+	if f.Cursor == "" {
+		return cursor{}, false
+	}
+	c, err := decodeCursor(f.CursorKey, f.Cursor)
+	if err != nil {
+		panic("unvalidated cursor parameter: " + f.Cursor)
+	}
+	return c, true
+}
+
+// The keysetCondition() method returns the comparison operators which select the
+// records after the cursor position (or before it, for a Before cursor). The first
+// operator applies to the sort column, and the second to the id tie-breaker, which is
+// always sorted in ascending order.
+func (f Filters) keysetCondition(c cursor) (string, string) {
+	column, id := ">", ">"
+	if f.sortDirection() == "DESC" {
+		column = "<"
+	}
+	if c.Before {
+		column, id = flipComparison(column), flipComparison(id)
+	}
+	return column, id
+}
+
+func flipComparison(op string) string {
+	if op == ">" {
+		return "<"
+	}
+	return ">"
 }
 
 func (f Filters) limit() int {
@@ -52,13 +106,17 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
-// Define a new Metadata struct for holding the pagination metadata.
+// Define a new Metadata struct for holding the pagination metadata. NextCursor and
+// PrevCursor can be sent back in the cursor parameter to fetch the neighbouring pages
+// with keyset pagination.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
 // The calculateMetadata() function calculates the appropriate pagination metadata
@@ -0,0 +1,306 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"finalProjectAdvancedP/internal/validator"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SlugRX matches a genre slug: lower-case letters and digits, in words separated by
+// single hyphens.
+var SlugRX = regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*$")
+
+var slugSeparatorRX = regexp.MustCompile(`[\s_]+`)
+
+// GenreSlug converts a genre name like "Science Fiction" into its slug,
+// "science-fiction". Books store their genres as slugs, so that differently capitalised
+// spellings of a genre all refer to the same one.
+func GenreSlug(name string) string {
+	return strings.ToLower(slugSeparatorRX.ReplaceAllString(strings.TrimSpace(name), "-"))
+}
+
+// genreSlugs converts each of the genre names to its slug. It never returns nil, so
+// that an empty list is still sent to PostgreSQL as an empty array.
+func genreSlugs(names []string) []string {
+	slugs := make([]string, 0, len(names))
+	for _, name := range names {
+		slugs = append(slugs, GenreSlug(name))
+	}
+	return slugs
+}
+
+// A Genre is a node in the genre taxonomy. Genres may have a parent genre, and a search
+// for the parent also matches books in any of the genres below it.
+type Genre struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	ParentID  *int64    `json:"parent_id,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// The Taxonomy holds every genre, indexed by both slug and id. It is loaded whenever
+// genres need checking, which is cheap as there are only ever a few hundred of them.
+type Taxonomy struct {
+	bySlug map[string]*Genre
+	byID   map[int64]*Genre
+}
+
+// The Has() method reports whether the genre name or slug is part of the taxonomy.
+func (t *Taxonomy) Has(name string) bool {
+	_, ok := t.bySlug[GenreSlug(name)]
+	return ok
+}
+
+// The createsCycle() method reports whether making parentID the parent of the genre
+// with the given id would create a loop, by walking up from the new parent. The walk is
+// bounded by the number of genres, in case the table already contains a loop.
+func (t *Taxonomy) createsCycle(id, parentID int64) bool {
+	g := t.byID[parentID]
+	for i := 0; g != nil && i <= len(t.byID); i++ {
+		if g.ID == id {
+			return true
+		}
+		if g.ParentID == nil {
+			return false
+		}
+		g = t.byID[*g.ParentID]
+	}
+	return g != nil
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre, taxonomy *Taxonomy) {
+	v.Check(strings.TrimSpace(genre.Name) != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(genre.Slug != "", "slug", "must be provided")
+	v.Check(len(genre.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+	v.Check(validator.Matches(genre.Slug, SlugRX), "slug", "must only contain lower-case letters, digits and single hyphens")
+
+	if genre.ParentID != nil {
+		_, ok := taxonomy.byID[*genre.ParentID]
+		v.Check(ok, "parent_id", "must refer to an existing genre")
+		v.Check(*genre.ParentID != genre.ID && !taxonomy.createsCycle(genre.ID, *genre.ParentID), "parent_id", "must not be the genre itself or one of its descendants")
+	}
+}
+
+// ValidateGenres checks a book's genres against the taxonomy. The genres are compared by
+// slug, so "Fantasy" and "fantasy" count as the same (and thus duplicate) genre.
+func ValidateGenres(v *validator.Validator, genres []string, taxonomy *Taxonomy) {
+	for _, genre := range genres {
+		if !taxonomy.Has(genre) {
+			v.AddError("genres", "must only contain known genres")
+			break
+		}
+	}
+	v.Check(validator.Unique(genreSlugs(genres)), "genres", "must not contain duplicate values")
+}
+
+// Define a GenreModel struct type which wraps a sql.DB connection pool.
+type GenreModel struct {
+	DB    *sql.DB
+	Cache *BookCache
+}
+
+func (m GenreModel) Insert(genre *Genre) error {
+	query := `
+		INSERT INTO genres (slug, name, parent_id)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, version`
+	args := []any{genre.Slug, strings.TrimSpace(genre.Name), genre.ParentID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&genre.ID, &genre.CreatedAt, &genre.Version)
+	if err != nil {
+		return translateError(err)
+	}
+	return nil
+}
+
+func (m GenreModel) Get(id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, slug, name, parent_id, version
+		FROM genres
+		WHERE id = $1`
+	var genre Genre
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&genre.ID,
+		&genre.CreatedAt,
+		&genre.Slug,
+		&genre.Name,
+		&genre.ParentID,
+		&genre.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &genre, nil
+}
+
+// The GetAll() method returns every genre, ordered by slug. The taxonomy is small, so
+// it isn't paginated.
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `
+		SELECT id, created_at, slug, name, parent_id, version
+		FROM genres
+		ORDER BY slug ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+	for rows.Next() {
+		var genre Genre
+		err := rows.Scan(&genre.ID, &genre.CreatedAt, &genre.Slug, &genre.Name, &genre.ParentID, &genre.Version)
+		if err != nil {
+			return nil, err
+		}
+		genres = append(genres, &genre)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return genres, nil
+}
+
+// The Taxonomy() method loads every genre into a Taxonomy.
+func (m GenreModel) Taxonomy() (*Taxonomy, error) {
+	genres, err := m.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	taxonomy := &Taxonomy{
+		bySlug: make(map[string]*Genre, len(genres)),
+		byID:   make(map[int64]*Genre, len(genres)),
+	}
+	for _, genre := range genres {
+		taxonomy.bySlug[genre.Slug] = genre
+		taxonomy.byID[genre.ID] = genre
+	}
+	return taxonomy, nil
+}
+
+// The Update() method saves changes to a genre. Books refer to their genres by slug, so
+// if the slug has changed they are updated to the new one in the same transaction, and
+// the change is recorded in their revision history as made by the user with userID.
+func (m GenreModel) Update(genre *Genre, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldSlug string
+	err = tx.QueryRowContext(ctx, `SELECT slug FROM genres WHERE id = $1 FOR UPDATE`, genre.ID).Scan(&oldSlug)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query := `
+		UPDATE genres
+		SET slug = $1, name = $2, parent_id = $3, updated_at = NOW(), version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+	args := []any{genre.Slug, strings.TrimSpace(genre.Name), genre.ParentID, genre.ID, genre.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&genre.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return translateError(err)
+		}
+	}
+
+	if oldSlug != genre.Slug {
+		query = `
+			UPDATE books
+			SET genres = array_replace(genres, $1, $2), version = version + 1
+			WHERE genres @> ARRAY[$1]
+			RETURNING id`
+		ids, err := queryIDs(ctx, tx, query, oldSlug, genre.Slug)
+		if err != nil {
+			return err
+		}
+		err = recordRevisions(ctx, tx, RevisionUpdate, userID, nil, ids...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return m.Cache.commit(tx)
+}
+
+// The Delete() method removes a genre. Genres which still have books or child genres
+// can't be deleted.
+func (m GenreModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM genres
+		WHERE id = $1 AND NOT EXISTS (
+			SELECT 1 FROM books WHERE books.genres @> ARRAY[genres.slug]
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		if errors.Is(translateError(err), ErrForeignKeyViolation) {
+			return &ConstraintError{Kind: ErrForeignKeyViolation, Field: "genre", Message: "still has child genres"}
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		// Tell apart a genre that doesn't exist from one which is still in use.
+		_, err = m.Get(id)
+		if err != nil {
+			return err
+		}
+		return &ConstraintError{Kind: ErrForeignKeyViolation, Field: "genre", Message: "is still used by one or more books"}
+	}
+	return nil
+}
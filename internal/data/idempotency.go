@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// An IdempotencyKey is a request made with an Idempotency-Key header, along with the
+// response it got. A key is scoped to the user who sent it and to the method and path
+// it was sent to. Anonymous requests share a UserID of 0, so Client tells apart the
+// clients which sent them; it is empty for authenticated requests. Status is 0 while
+// the request is still being processed.
+type IdempotencyKey struct {
+	UserID      int64
+	Client      string
+	Key         string
+	Method      string
+	Path        string
+	RequestHash []byte
+	Status      int
+	Header      map[string][]string
+	Body        []byte
+}
+
+// Define an IdempotencyModel struct type which wraps a sql.DB connection pool.
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+// The Begin() method claims the key for a new request, which is then kept for ttl. It
+// returns nil if the key was free: the caller should process the request and then call
+// Complete() or Release(). Otherwise it returns the key as it was stored by an earlier
+// request, which may still be in progress. A key whose request has been in progress for
+// longer than lockTimeout is assumed to have been abandoned (say because the server was
+// restarted), and is claimed afresh.
+func (m IdempotencyModel) Begin(key *IdempotencyKey, ttl, lockTimeout time.Duration) (*IdempotencyKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO idempotency_keys (user_id, client, key, method, path, request_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW() + make_interval(secs => $7))
+		ON CONFLICT (user_id, client, key, method, path) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status = NULL, header = NULL, body = NULL,
+			created_at = NOW(), expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < NOW()
+			OR (idempotency_keys.status IS NULL AND idempotency_keys.created_at < NOW() - make_interval(secs => $8))
+		RETURNING true`
+	args := []any{key.UserID, key.Client, key.Key, key.Method, key.Path, key.RequestHash, ttl.Seconds(), lockTimeout.Seconds()}
+
+	var claimed bool
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&claimed)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// The key is taken, so fetch what the earlier request stored under it.
+	query = `
+		SELECT request_hash, COALESCE(status, 0), header, body
+		FROM idempotency_keys
+		WHERE user_id = $1 AND client = $2 AND key = $3 AND method = $4 AND path = $5`
+
+	stored := &IdempotencyKey{UserID: key.UserID, Client: key.Client, Key: key.Key, Method: key.Method, Path: key.Path}
+	var header []byte
+	err = m.DB.QueryRowContext(ctx, query, key.UserID, key.Client, key.Key, key.Method, key.Path).Scan(&stored.RequestHash, &stored.Status, &header, &stored.Body)
+	if err != nil {
+		// The key may have been released in the meantime. Report it as in progress,
+		// and let the client try again.
+		if errors.Is(err, sql.ErrNoRows) {
+			return stored, nil
+		}
+		return nil, err
+	}
+	if header != nil {
+		err = json.Unmarshal(header, &stored.Header)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stored, nil
+}
+
+// The Complete() method stores the response to the request which claimed the key.
+func (m IdempotencyModel) Complete(key *IdempotencyKey) error {
+	header, err := json.Marshal(key.Header)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE idempotency_keys
+		SET status = $1, header = $2, body = $3
+		WHERE user_id = $4 AND client = $5 AND key = $6 AND method = $7 AND path = $8 AND status IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, key.Status, header, key.Body, key.UserID, key.Client, key.Key, key.Method, key.Path)
+	return err
+}
+
+// The Release() method gives up the claim on a key without storing a response, so that
+// the request can be retried with the same key.
+func (m IdempotencyModel) Release(key *IdempotencyKey) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND client = $2 AND key = $3 AND method = $4 AND path = $5 AND status IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key.UserID, key.Client, key.Key, key.Method, key.Path)
+	return err
+}
+
+// The DeleteExpired() method removes the keys which have expired, and returns how many
+// there were.
+func (m IdempotencyModel) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
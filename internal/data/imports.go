@@ -0,0 +1,324 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// importBatchSize is the number of rows copied into the database and merged into the
+// catalog at a time.
+const importBatchSize = 500
+
+// The statuses of an imported row.
+const (
+	ImportCreated  = "created"
+	ImportUpdated  = "updated"
+	ImportRejected = "rejected"
+)
+
+// An ImportRow is a book read from one row (or line) of an import file. Row is the
+// 1-based row number, which is used to report back on the row.
+type ImportRow struct {
+	Row  int
+	Book *Book
+}
+
+// An ImportResult reports what happened to one row of an import file.
+type ImportResult struct {
+	Row    int               `json:"row"`
+	Status string            `json:"status"`
+	ID     int64             `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// The Import() method merges the rows into the catalog. A row which has the same ISBN-13
+// as an existing (not deleted) book, or otherwise the same title and author (ignoring
+// case), updates that book; the other rows are inserted as new books. The rows should
+// already have been validated with ValidateBook(). A row's authors are looked up (or
+// created) by the names in its Authors, or else its author string is taken as the name
+// of a single author, as when creating a book without author_ids.
+// Every created or updated book gets a revision made by the user with userID.
+//
+// The rows are copied into a temporary table with COPY in batches, and merged from
+// there with a handful of statements per batch. When atomic is true, all the batches
+// run in a single transaction, so either every row is imported or none of them is and
+// the error is returned. Otherwise each batch is committed on its own, and a batch which
+// fails is imported again one row at a time, so that the good rows are still imported
+// and each bad row is rejected with its own error.
+func (m BookModel) Import(ctx context.Context, rows []ImportRow, atomic bool, userID int64) ([]ImportResult, error) {
+	results := make([]ImportResult, 0, len(rows))
+
+	// COPY isn't available through database/sql, so we borrow a connection from the
+	// pool and use the underlying pgx connection directly.
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	err = conn.Raw(func(driverConn any) error {
+		pgxConn := driverConn.(*stdlib.Conn).Conn()
+
+		var tx pgx.Tx
+		if atomic {
+			tx, err = pgxConn.Begin(ctx)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback(ctx)
+		}
+
+		for start := 0; start < len(rows); start += importBatchSize {
+			end := start + importBatchSize
+			if end > len(rows) {
+				end = len(rows)
+			}
+			batch := rows[start:end]
+
+			if atomic {
+				batchResults, err := importBatch(ctx, tx, batch, userID)
+				if err != nil {
+					return translateError(err)
+				}
+				results = append(results, batchResults...)
+				continue
+			}
+
+			batchResults, err := importBatchTx(ctx, pgxConn, batch, userID)
+			if err == nil {
+				results = append(results, batchResults...)
+				continue
+			}
+
+			// One bad row fails the whole batch, so find out which rows it was by
+			// trying each row on its own. Give up on the whole import if the request
+			// has been cancelled or has timed out, as every further row would fail in
+			// the same way.
+			for i := range batch {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				rowResults, err := importBatchTx(ctx, pgxConn, batch[i:i+1], userID)
+				if err != nil {
+					rowResults = []ImportResult{rejectRow(batch[i], translateError(err))}
+				}
+				results = append(results, rowResults...)
+			}
+		}
+
+		if atomic {
+			return tx.Commit(ctx)
+		}
+		return nil
+	})
+	// Some batches may have been committed even if a later one failed, so the cached
+	// catalog is dropped either way.
+	m.Cache.invalidate()
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// The importBatchTx() helper imports a batch in a transaction of its own.
+func importBatchTx(ctx context.Context, conn *pgx.Conn, batch []ImportRow, userID int64) ([]ImportResult, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	results, err := importBatch(ctx, tx, batch, userID)
+	if err != nil {
+		return nil, err
+	}
+	return results, tx.Commit(ctx)
+}
+
+// The rejectRow() helper reports a row which couldn't be saved as rejected, with the
+// reason when it is known.
+func rejectRow(row ImportRow, err error) ImportResult {
+	errs := map[string]string{"row": "could not be saved"}
+	var constraintErr *ConstraintError
+	if errors.As(err, &constraintErr) {
+		errs = map[string]string{constraintErr.Field: constraintErr.Message}
+	}
+	return ImportResult{Row: row.Row, Status: ImportRejected, Errors: errs}
+}
+
+// The importBatch() helper copies a batch of rows into the import_rows temporary table
+// and merges them into books. The temporary table only lives until the end of the
+// transaction. Statements on it aren't cached, as the table is recreated for every
+// transaction.
+func importBatch(ctx context.Context, tx pgx.Tx, batch []ImportRow, userID int64) ([]ImportResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	exec := func(sql string) error {
+		_, err := tx.Exec(ctx, sql, pgx.QueryExecModeExec)
+		return err
+	}
+
+	err := exec(`
+		CREATE TEMPORARY TABLE IF NOT EXISTS import_rows (
+			row integer NOT NULL,
+			title text NOT NULL,
+			author text NOT NULL,
+			authors text[] NOT NULL,
+			year integer NOT NULL,
+			genres text[] NOT NULL,
+			price bigint NOT NULL,
+			isbn10 text NOT NULL,
+			isbn13 text NOT NULL,
+			book_id bigint,
+			created boolean NOT NULL DEFAULT false,
+			relink boolean NOT NULL DEFAULT true
+		) ON COMMIT DROP`)
+	if err != nil {
+		return nil, err
+	}
+	err = exec(`TRUNCATE import_rows`)
+	if err != nil {
+		return nil, err
+	}
+
+	copyRows := make([][]any, len(batch))
+	for i, row := range batch {
+		book := row.Book
+		book.fillISBNs()
+		book.Genres = genreSlugs(book.Genres)
+		authors := []string{strings.TrimSpace(book.Author)}
+		if len(book.Authors) > 0 {
+			authors = make([]string, len(book.Authors))
+			for j, author := range book.Authors {
+				authors[j] = strings.TrimSpace(author.Name)
+			}
+			book.Author = strings.Join(authors, ", ")
+		}
+		book.Author = strings.TrimSpace(book.Author)
+		copyRows[i] = []any{row.Row, book.Title, book.Author, authors, book.Year, book.Genres, int64(book.Price), book.ISBN10, book.ISBN13}
+	}
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"import_rows"},
+		[]string{"row", "title", "author", "authors", "year", "genres", "price", "isbn10", "isbn13"},
+		pgx.CopyFromRows(copyRows),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	statements := []string{
+		// Match rows to existing books, first by ISBN-13 and then by title and author.
+		`UPDATE import_rows SET book_id = books.id
+		FROM books
+		WHERE import_rows.isbn13 <> '' AND books.isbn13 = import_rows.isbn13
+		AND books.deleted_at IS NULL`,
+
+		`UPDATE import_rows SET book_id = books.id
+		FROM books
+		WHERE import_rows.book_id IS NULL
+		AND lower(books.title) = lower(import_rows.title)
+		AND lower(books.author) = lower(import_rows.author)
+		AND books.deleted_at IS NULL`,
+
+		// Books whose author string hasn't changed keep their author links, which may
+		// list several authors.
+		`UPDATE import_rows SET relink = false
+		FROM books
+		WHERE books.id = import_rows.book_id AND lower(books.author) = lower(import_rows.author)`,
+
+		// Give the new books their ids up front, so that we know which row became which
+		// book.
+		`UPDATE import_rows SET book_id = nextval(pg_get_serial_sequence('books', 'id')), created = true
+		WHERE book_id IS NULL`,
+
+		`INSERT INTO books (id, title, year, genres, author, price, isbn10, isbn13)
+		SELECT book_id, title, year, genres, author, price, NULLIF(isbn10, ''), NULLIF(isbn13, '')
+		FROM import_rows
+		WHERE created`,
+
+		`UPDATE books
+		SET title = import_rows.title,
+			year = import_rows.year,
+			genres = import_rows.genres,
+			author = import_rows.author,
+			price = import_rows.price,
+			isbn10 = COALESCE(NULLIF(import_rows.isbn10, ''), books.isbn10),
+			isbn13 = COALESCE(NULLIF(import_rows.isbn13, ''), books.isbn13),
+			version = books.version + 1
+		FROM import_rows
+		WHERE books.id = import_rows.book_id AND NOT import_rows.created`,
+
+		// Create any authors we don't know yet, and link the books to them, in the order
+		// they are listed.
+		`INSERT INTO authors (name)
+		SELECT DISTINCT ON (lower(a.name)) a.name
+		FROM import_rows, unnest(import_rows.authors) AS a(name)
+		WHERE import_rows.relink
+		ORDER BY lower(a.name), a.name
+		ON CONFLICT DO NOTHING`,
+
+		`DELETE FROM book_authors
+		USING import_rows
+		WHERE book_authors.book_id = import_rows.book_id AND import_rows.relink`,
+
+		`INSERT INTO book_authors (book_id, author_id, position)
+		SELECT DISTINCT ON (import_rows.book_id, authors.id) import_rows.book_id, authors.id, a.position - 1
+		FROM import_rows
+		CROSS JOIN unnest(import_rows.authors) WITH ORDINALITY AS a(name, position)
+		INNER JOIN authors ON lower(authors.name) = lower(a.name)
+		WHERE import_rows.relink
+		ORDER BY import_rows.book_id, authors.id, a.position`,
+	}
+	for _, statement := range statements {
+		err = exec(statement)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pgRows, err := tx.Query(ctx, `SELECT row, book_id, created FROM import_rows ORDER BY row`, pgx.QueryExecModeExec)
+	if err != nil {
+		return nil, err
+	}
+	defer pgRows.Close()
+
+	results := make([]ImportResult, 0, len(batch))
+	ids := map[string][]int64{RevisionCreate: {}, RevisionUpdate: {}}
+	for pgRows.Next() {
+		var result ImportResult
+		var created bool
+		err = pgRows.Scan(&result.Row, &result.ID, &created)
+		if err != nil {
+			return nil, err
+		}
+		action := RevisionUpdate
+		result.Status = ImportUpdated
+		if created {
+			result.Status, action = ImportCreated, RevisionCreate
+		}
+		results = append(results, result)
+		ids[action] = append(ids[action], result.ID)
+	}
+	pgRows.Close()
+	if err = pgRows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Record the revisions of the created and updated books.
+	for _, action := range []string{RevisionCreate, RevisionUpdate} {
+		if len(ids[action]) == 0 {
+			continue
+		}
+		_, err = tx.Exec(ctx, recordRevisionsQuery, action, userID, nil, ids[action])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -11,17 +12,59 @@ var (
 )
 
 type Models struct {
-	Users  UserModel
-	Tokens TokenModel
-	Books  BookModel
-	Carts  CartModel
+	Users       UserModel
+	Tokens      TokenModel
+	Books       BookModel
+	Carts       CartModel
+	Authors     AuthorModel
+	Publishers  PublisherModel
+	Genres      GenreModel
+	Revisions   BookRevisionModel
+	Idempotency IdempotencyModel
 }
 
-func NewModels(db *sql.DB) Models {
+// The querier interface is satisfied by both *sql.DB and *sql.Tx, so that helpers which
+// take one can be used both inside and outside a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// The queryIDs() helper runs a query which returns a single column of ids, and collects
+// them.
+func queryIDs(ctx context.Context, q querier, query string, args ...any) ([]int64, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// NewModels returns a Models struct using db as the primary database. Read-only catalog
+// queries are routed through replicas, which may be configured with no replicas at all.
+// Books are cached in bookCache, which may be nil to turn caching off.
+func NewModels(db *sql.DB, replicas *Replicas, bookCache *BookCache) Models {
 	return Models{
-		Users:  UserModel{DB: db}, // initialize a new UserModel instance
-		Tokens: TokenModel{DB: db},
-		Books:  BookModel{DB: db},
-		Carts:  CartModel{DB: db},
+		Users:       UserModel{DB: db}, // initialize a new UserModel instance
+		Tokens:      TokenModel{DB: db},
+		Books:       BookModel{DB: db, Replicas: replicas, Cache: bookCache},
+		Carts:       CartModel{DB: db},
+		Authors:     AuthorModel{DB: db, Cache: bookCache},
+		Publishers:  PublisherModel{DB: db, Cache: bookCache},
+		Genres:      GenreModel{DB: db, Cache: bookCache},
+		Revisions:   BookRevisionModel{DB: db},
+		Idempotency: IdempotencyModel{DB: db},
 	}
 }
@@ -0,0 +1,193 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"finalProjectAdvancedP/internal/validator"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Publisher struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Website   string    `json:"website,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+func ValidatePublisher(v *validator.Validator, publisher *Publisher) {
+	v.Check(strings.TrimSpace(publisher.Name) != "", "name", "must be provided")
+	v.Check(len(publisher.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(len(publisher.Website) <= 500, "website", "must not be more than 500 bytes long")
+}
+
+// Define a PublisherModel struct type which wraps a sql.DB connection pool.
+type PublisherModel struct {
+	DB    *sql.DB
+	Cache *BookCache
+}
+
+func (m PublisherModel) Insert(publisher *Publisher) error {
+	query := `
+		INSERT INTO publishers (name, website)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version`
+	args := []any{strings.TrimSpace(publisher.Name), publisher.Website}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&publisher.ID, &publisher.CreatedAt, &publisher.Version)
+	if err != nil {
+		return translateError(err)
+	}
+	return nil
+}
+
+func (m PublisherModel) Get(id int64) (*Publisher, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, website, version
+		FROM publishers
+		WHERE id = $1`
+	var publisher Publisher
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&publisher.ID,
+		&publisher.CreatedAt,
+		&publisher.Name,
+		&publisher.Website,
+		&publisher.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &publisher, nil
+}
+
+func (m PublisherModel) GetAll(name string, filters Filters) ([]*Publisher, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, website, version
+		FROM publishers
+		WHERE name ILIKE $1
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+	args := []any{"%" + escapeLike(name) + "%", filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	publishers := []*Publisher{}
+	for rows.Next() {
+		var publisher Publisher
+		err := rows.Scan(&totalRecords, &publisher.ID, &publisher.CreatedAt, &publisher.Name, &publisher.Website, &publisher.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		publishers = append(publishers, &publisher)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return publishers, metadata, nil
+}
+
+func (m PublisherModel) Update(publisher *Publisher) error {
+	query := `
+		UPDATE publishers
+		SET name = $1, website = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+	args := []any{strings.TrimSpace(publisher.Name), publisher.Website, publisher.ID, publisher.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&publisher.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return translateError(err)
+		}
+	}
+	// The publisher is embedded in each of their books, so drop the cached ones.
+	m.Cache.invalidate()
+	return nil
+}
+
+// The Delete() method removes a publisher. Their books are kept, and simply no longer
+// have a publisher. The books are detached in the same transaction, rather than left to
+// the foreign key's ON DELETE SET NULL, so that the change bumps their versions and is
+// recorded in their revision history as made by the user with userID.
+func (m PublisherModel) Delete(id int64, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE books
+		SET publisher_id = NULL, version = version + 1
+		WHERE publisher_id = $1
+		RETURNING id`
+	ids, err := queryIDs(ctx, tx, query, id)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM publishers
+		WHERE id = $1`
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = recordRevisions(ctx, tx, RevisionUpdate, userID, nil, ids...)
+	if err != nil {
+		return err
+	}
+	return m.Cache.commit(tx)
+}
@@ -0,0 +1,162 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// replica holds the connection pool for a single read replica along with its current
+// health status.
+type replica struct {
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// The Replicas type routes read-only queries across a set of read replicas in
+// round-robin order. Replicas which fail a health check or can't be reached are skipped
+// until they pass a health check again, and if no replica is healthy the primary is used.
+type Replicas struct {
+	primary *sql.DB
+	pools   []*replica
+	next    atomic.Uint32
+}
+
+// NewReplicas returns a Replicas instance for the given primary and replica connection
+// pools. All replicas start off as healthy, until CheckHealth() says otherwise. It is
+// fine to pass no replicas at all, in which case every read goes to the primary.
+func NewReplicas(primary *sql.DB, pools ...*sql.DB) *Replicas {
+	r := &Replicas{primary: primary}
+	for _, db := range pools {
+		rep := &replica{db: db}
+		rep.healthy.Store(true)
+		r.pools = append(r.pools, rep)
+	}
+	return r
+}
+
+// reader returns the next healthy replica, or nil if there are none.
+func (r *Replicas) reader() *replica {
+	n := len(r.pools)
+	for i := 0; i < n; i++ {
+		rep := r.pools[int(r.next.Add(1)-1)%n]
+		if rep.healthy.Load() {
+			return rep
+		}
+	}
+	return nil
+}
+
+// The pick() method returns a healthy replica's connection pool, or the primary's if
+// there are none. Unlike read(), it doesn't retry failures on the primary, so it suits
+// long streaming reads which can't simply be repeated once they have produced output.
+func (r *Replicas) pick() *sql.DB {
+	if rep := r.reader(); rep != nil {
+		return rep.db
+	}
+	return r.primary
+}
+
+// The read() method runs fn against a healthy replica. If the replica can't be reached
+// it is marked as unhealthy and fn is run again against the primary. Any other error,
+// such as a timeout or a failed query, would most likely happen on the primary too, so
+// it is returned as it is and the replica stays in rotation.
+func (r *Replicas) read(fn func(db *sql.DB) error) error {
+	rep := r.reader()
+	if rep == nil {
+		return fn(r.primary)
+	}
+
+	err := fn(rep.db)
+	if err == nil || !isConnectionError(err) {
+		return err
+	}
+
+	rep.healthy.Store(false)
+	return fn(r.primary)
+}
+
+// The isConnectionError() function reports whether err means that the database couldn't
+// be reached, rather than that the query itself failed. Timeouts and cancellations
+// come from the query's context, so they don't count.
+func isConnectionError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.As(err, &connectErr) || errors.As(err, &netErr)
+}
+
+// The CheckHealth() method checks every replica, updating its health status. A replica
+// is healthy if it can be reached and is no more than MaxReplicaLag behind the primary.
+// It returns the error for each unhealthy replica, in the order the replicas were given
+// to NewReplicas(), with nil for the healthy ones.
+func (r *Replicas) CheckHealth() []error {
+	errs := make([]error, len(r.pools))
+	for i, rep := range r.pools {
+		errs[i] = checkReplica(rep.db)
+		rep.healthy.Store(errs[i] == nil)
+	}
+	return errs
+}
+
+// MonitorHealth checks every replica once per interval (see CheckHealth()), so that one
+// which goes away is taken out of rotation and put back once it recovers. It blocks
+// forever, so it should be run in its own goroutine.
+func (r *Replicas) MonitorHealth(interval time.Duration) {
+	if len(r.pools) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.CheckHealth()
+	}
+}
+
+// The checkReplica() function returns an error if the replica can't be reached, or if
+// it has fallen more than MaxReplicaLag behind the primary. The lag is the time since
+// the last transaction it replayed, unless it has replayed everything it has received.
+// A server which isn't replicating at all has no lag.
+func checkReplica(db *sql.DB) error {
+	query := `
+		SELECT COALESCE(
+			CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()) END,
+		0)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var lag float64
+	err := db.QueryRowContext(ctx, query).Scan(&lag)
+	if err != nil {
+		return err
+	}
+	if lag > MaxReplicaLag.Seconds() {
+		return fmt.Errorf("replica is %.1fs behind the primary", lag)
+	}
+	return nil
+}
+
+// Close closes the connection pools for all the replicas. The primary is left open.
+func (r *Replicas) Close() error {
+	var firstErr error
+	for _, rep := range r.pools {
+		if err := rep.db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
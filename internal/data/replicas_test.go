@@ -0,0 +1,108 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"bad connection", driver.ErrBadConn, true},
+		{"wrapped bad connection", fmt.Errorf("query: %w", driver.ErrBadConn), true},
+		{"network error", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+		{"canceled", fmt.Errorf("query: %w", context.Canceled), false},
+		{"no rows", sql.ErrNoRows, false},
+		{"query error", errors.New(`column "nope" does not exist`), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConnectionError(tt.err); got != tt.want {
+				t.Errorf("isConnectionError(%v) = %t; want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// A switchableConnector connects to a stand-in replica which can be taken down and
+// brought back. While it is up, it answers every query with a single 0, which is what
+// a replica with no lag answers the health check with.
+type switchableConnector struct {
+	down atomic.Bool
+}
+
+func (c *switchableConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if c.down.Load() {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	return switchableConn{}, nil
+}
+
+func (c *switchableConnector) Driver() driver.Driver { return nil }
+
+type switchableConn struct{}
+
+func (switchableConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("switchable driver: Prepare isn't supported")
+}
+func (switchableConn) Close() error { return nil }
+func (switchableConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("switchable driver: Begin isn't supported")
+}
+
+func (switchableConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return &recordingRows{columns: []string{"lag"}, values: [][]driver.Value{{float64(0)}}}, nil
+}
+
+// A replica which is down when the application starts is kept out of rotation, and put
+// back once it passes a health check.
+func TestReplicaDownAtStart(t *testing.T) {
+	primary, err := sql.Open("pgx", "postgres://primary.invalid/bookstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	connector := &switchableConnector{}
+	connector.down.Store(true)
+	replica := sql.OpenDB(connector)
+	defer replica.Close()
+
+	r := NewReplicas(primary, replica)
+	errs := r.CheckHealth()
+	if len(errs) != 1 || errs[0] == nil {
+		t.Fatalf("CheckHealth() = %v; want an error for the replica", errs)
+	}
+
+	readFrom := func() *sql.DB {
+		var got *sql.DB
+		r.read(func(db *sql.DB) error {
+			got = db
+			return nil
+		})
+		return got
+	}
+	if readFrom() != primary {
+		t.Error("read() used the replica which is down; want the primary")
+	}
+
+	connector.down.Store(false)
+	errs = r.CheckHealth()
+	if errs[0] != nil {
+		t.Fatalf("CheckHealth() after recovery = %v; want no error", errs[0])
+	}
+	if readFrom() != replica {
+		t.Error("read() didn't use the replica once it recovered")
+	}
+}
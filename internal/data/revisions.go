@@ -0,0 +1,260 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// The actions recorded in a book's revision history.
+const (
+	RevisionCreate  = "create"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionRevert  = "revert"
+	RevisionPurge   = "purge"
+)
+
+// A BookRevision records one change to a book: who made it, what the book looked like
+// afterwards (the snapshot) and which fields changed compared to the revision before.
+// User is nil for changes made by the system, or by users who have since been deleted.
+type BookRevision struct {
+	ID           int64                     `json:"id"`
+	CreatedAt    time.Time                 `json:"created_at"`
+	BookID       int64                     `json:"book_id"`
+	Version      int32                     `json:"version"`
+	Action       string                    `json:"action"`
+	User         *RevisionUser             `json:"user"`
+	RevertedFrom *int64                    `json:"reverted_from,omitempty"`
+	Changes      map[string]RevisionChange `json:"changes"`
+	Snapshot     json.RawMessage           `json:"snapshot"`
+}
+
+// A RevisionUser identifies the user who made a change.
+type RevisionUser struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// A RevisionChange holds the old and new value of a changed field, as JSON. From is
+// null for the fields of a newly created book.
+type RevisionChange struct {
+	From json.RawMessage `json:"from"`
+	To   json.RawMessage `json:"to"`
+}
+
+// A BookSnapshot is the state of a book kept in a revision, as built by the
+// book_snapshot() database function. The ISBNs and cover URL are nil when unknown.
+type BookSnapshot struct {
+	Title       string     `json:"title"`
+	Year        int32      `json:"year"`
+	Author      string     `json:"author"`
+	AuthorIDs   []int64    `json:"author_ids"`
+	Genres      []string   `json:"genres"`
+	Price       uint64     `json:"price"`
+	PublisherID *int64     `json:"publisher_id"`
+	ISBN10      *string    `json:"isbn10"`
+	ISBN13      *string    `json:"isbn13"`
+	CoverURL    *string    `json:"cover_url"`
+	DeletedAt   *time.Time `json:"deleted_at"`
+}
+
+// The Book() method decodes the revision's snapshot.
+func (rev *BookRevision) Book() (*BookSnapshot, error) {
+	var snapshot BookSnapshot
+	err := json.Unmarshal(rev.Snapshot, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// recordRevisionsQuery adds a revision for each of the books, diffing their current
+// snapshot against the snapshot in their latest revision. The user id is stored as NULL
+// when it is 0, that is for anonymous users and the system itself.
+const recordRevisionsQuery = `
+INSERT INTO book_revisions (book_id, version, action, user_id, reverted_from, snapshot, changes)
+SELECT books.id, books.version, $1, NULLIF($2::bigint, 0), $3::bigint, snapshot.data,
+	(SELECT COALESCE(jsonb_object_agg(new.key, jsonb_build_object('from', old.value, 'to', new.value)), '{}')
+	FROM jsonb_each(snapshot.data) AS new
+	LEFT JOIN jsonb_each(previous.snapshot) AS old ON old.key = new.key
+	WHERE old.value IS DISTINCT FROM new.value)
+FROM books
+CROSS JOIN LATERAL (SELECT book_snapshot(books) AS data) AS snapshot
+LEFT JOIN LATERAL (
+	SELECT book_revisions.snapshot
+	FROM book_revisions
+	WHERE book_revisions.book_id = books.id
+	ORDER BY book_revisions.id DESC
+	LIMIT 1
+) AS previous ON true
+WHERE books.id = ANY($4::bigint[])
+ORDER BY books.id`
+
+// The recordRevisions() helper records the current state of the books in their revision
+// history. It should be called in the same transaction as the change itself, once the
+// change has been made.
+func recordRevisions(ctx context.Context, q querier, action string, userID int64, revertedFrom *int64, bookIDs ...int64) error {
+	if len(bookIDs) == 0 {
+		return nil
+	}
+	_, err := q.ExecContext(ctx, recordRevisionsQuery, action, userID, revertedFrom, bookIDs)
+	return err
+}
+
+// Define a BookRevisionModel struct type which wraps a sql.DB connection pool. The
+// revisions themselves are written by the BookModel methods which change books.
+type BookRevisionModel struct {
+	DB *sql.DB
+}
+
+const revisionColumns = `book_revisions.id, book_revisions.created_at, book_revisions.book_id,
+	book_revisions.version, book_revisions.action, book_revisions.user_id, COALESCE(users.name, ''),
+	book_revisions.reverted_from, book_revisions.changes, book_revisions.snapshot`
+
+// A revisionRow holds a row of revisionColumns while it is scanned.
+type revisionRow struct {
+	rev      BookRevision
+	userID   *int64
+	userName string
+	changes  []byte
+	snapshot []byte
+}
+
+func (row *revisionRow) scanTargets() []any {
+	return []any{
+		&row.rev.ID,
+		&row.rev.CreatedAt,
+		&row.rev.BookID,
+		&row.rev.Version,
+		&row.rev.Action,
+		&row.userID,
+		&row.userName,
+		&row.rev.RevertedFrom,
+		&row.changes,
+		&row.snapshot,
+	}
+}
+
+// The revision() method returns the scanned revision, with its user and changes filled in.
+func (row *revisionRow) revision() (*BookRevision, error) {
+	rev := row.rev
+	rev.Snapshot = row.snapshot
+	if row.userID != nil {
+		rev.User = &RevisionUser{ID: *row.userID, Name: row.userName}
+	}
+	err := json.Unmarshal(row.changes, &rev.Changes)
+	if err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// The GetAll() method returns a page of the book's revisions, newest first.
+func (m BookRevisionModel) GetAll(bookID int64, filters Filters) ([]*BookRevision, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), ` + revisionColumns + `
+		FROM book_revisions
+		LEFT JOIN users ON users.id = book_revisions.user_id
+		WHERE book_revisions.book_id = $1
+		ORDER BY book_revisions.id DESC
+		LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, bookID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*BookRevision{}
+	for rows.Next() {
+		var row revisionRow
+		err := rows.Scan(append([]any{&totalRecords}, row.scanTargets()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		rev, err := row.revision()
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		revisions = append(revisions, rev)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return revisions, metadata, nil
+}
+
+// The Get() method fetches one of the book's revisions.
+func (m BookRevisionModel) Get(bookID, id int64) (*BookRevision, error) {
+	if bookID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + revisionColumns + `
+		FROM book_revisions
+		LEFT JOIN users ON users.id = book_revisions.user_id
+		WHERE book_revisions.book_id = $1 AND book_revisions.id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var row revisionRow
+	err := m.DB.QueryRowContext(ctx, query, bookID, id).Scan(row.scanTargets()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return row.revision()
+}
+
+// The LastModified() method returns when the book with the given id was last changed,
+// according to its revision history. With a bookID of 0 it returns when the catalog
+// was last changed, which is used as the modification time of lists of books: that is
+// when any book was changed, or any author, publisher or genre (whose names are part of
+// their books). It returns the zero time if nothing has been recorded.
+func (m BookRevisionModel) LastModified(bookID int64) (time.Time, error) {
+	return lastModified(m.DB, bookID)
+}
+
+// The lastModified() function runs the LastModified() query on db, so that it can also
+// be run on a read replica (see BookModel.LastModified()).
+func lastModified(db *sql.DB, bookID int64) (time.Time, error) {
+	// The two cases get separate queries, so that each can use its own indexes.
+	query := `
+		SELECT GREATEST(
+			(SELECT created_at FROM book_revisions ORDER BY id DESC LIMIT 1),
+			(SELECT max(updated_at) FROM authors),
+			(SELECT max(updated_at) FROM publishers),
+			(SELECT max(updated_at) FROM genres)
+		)`
+	args := []any{}
+	if bookID != 0 {
+		query = `SELECT created_at FROM book_revisions WHERE book_id = $1 ORDER BY id DESC LIMIT 1`
+		args = append(args, bookID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var lastModified sql.NullTime
+	err := db.QueryRowContext(ctx, query, args...).Scan(&lastModified)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, err
+	}
+	return lastModified.Time, nil
+}
@@ -0,0 +1,172 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"finalProjectAdvancedP/internal/validator"
+	"html"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A Suggestion is a book matching a partially typed search, used for autocomplete. The
+// highlight fields hold the title and author with the matching words wrapped in <em>
+// tags (and everything else HTML-escaped).
+type Suggestion struct {
+	ID              int64   `json:"id"`
+	Title           string  `json:"title"`
+	Author          string  `json:"author"`
+	TitleHighlight  string  `json:"title_highlight"`
+	AuthorHighlight string  `json:"author_highlight"`
+	Score           float64 `json:"score"`
+}
+
+func ValidateSuggestQuery(v *validator.Validator, q string, limit int) {
+	v.Check(q != "", "q", "must be provided")
+	v.Check(len(q) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 50, "limit", "must be a maximum of 50")
+}
+
+// The Suggest() method returns up to limit books whose title or author contains q, or
+// is similar to it with a trigram word similarity of at least threshold. Substring
+// matches are listed first, followed by the rest in order of similarity.
+func (m BookModel) Suggest(q string, limit int, threshold float64) ([]*Suggestion, error) {
+	var suggestions []*Suggestion
+	err := m.Replicas.read(func(db *sql.DB) error {
+		var err error
+		suggestions, err = m.suggest(db, q, limit, threshold)
+		return err
+	})
+	return suggestions, err
+}
+
+func (m BookModel) suggest(db *sql.DB, q string, limit int, threshold float64) ([]*Suggestion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// The <% operator is what lets PostgreSQL use the trigram indexes, but it compares
+	// against the pg_trgm.word_similarity_threshold setting rather than taking the
+	// threshold as an argument. So we set it for the length of a read-only transaction.
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = setSimilarityThreshold(ctx, tx, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+SELECT id, title, author, greatest(word_similarity($1, title), word_similarity($1, author)) AS score
+FROM books
+WHERE (title ILIKE $2 OR author ILIKE $2 OR $1 <% title OR $1 <% author)
+AND deleted_at IS NULL
+ORDER BY (title ILIKE $2 OR author ILIKE $2) DESC, score DESC, id ASC
+LIMIT $3`
+	args := []any{q, "%" + escapeLike(q) + "%", limit}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*Suggestion{}
+	for rows.Next() {
+		var s Suggestion
+		err := rows.Scan(&s.ID, &s.Title, &s.Author, &s.Score)
+		if err != nil {
+			return nil, err
+		}
+		s.TitleHighlight = highlight(s.Title, q, threshold)
+		s.AuthorHighlight = highlight(s.Author, q, threshold)
+		suggestions = append(suggestions, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
+
+// The escapeLike() helper escapes the characters which have a special meaning in a LIKE
+// pattern, so that s is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// The highlight() function wraps each word in text which matches a word in query in
+// <em> tags, HTML-escaping the rest of the text. A word matches if it starts with a
+// query word, or if its trigram similarity to one (the same measure that pg_trgm uses)
+// is at least threshold.
+func highlight(text, query string, threshold float64) string {
+	terms := strings.FieldsFunc(strings.ToLower(query), isWordSeparator)
+
+	var b strings.Builder
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		// Copy any separators straight through.
+		if isWordSeparator(runes[i]) {
+			b.WriteString(html.EscapeString(string(runes[i])))
+			i++
+			continue
+		}
+
+		// Otherwise find the end of the word and check it against the query terms.
+		j := i
+		for j < len(runes) && !isWordSeparator(runes[j]) {
+			j++
+		}
+		word := string(runes[i:j])
+		if matchesTerm(strings.ToLower(word), terms, threshold) {
+			b.WriteString("<em>" + html.EscapeString(word) + "</em>")
+		} else {
+			b.WriteString(html.EscapeString(word))
+		}
+		i = j
+	}
+	return b.String()
+}
+
+func isWordSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+func matchesTerm(word string, terms []string, threshold float64) bool {
+	for _, term := range terms {
+		if strings.HasPrefix(word, term) || trigramSimilarity(word, term) >= threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// The trigramSimilarity() function returns the number of trigrams shared by two words
+// divided by the number of distinct trigrams in either of them. As in pg_trgm, each
+// word is padded with two spaces at the start and one at the end before being split.
+func trigramSimilarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+func trigrams(word string) map[string]bool {
+	runes := []rune("  " + word + " ")
+	set := make(map[string]bool)
+	for i := 0; i+3 <= len(runes); i++ {
+		set[string(runes[i:i+3])] = true
+	}
+	return set
+}
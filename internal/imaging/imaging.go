@@ -0,0 +1,127 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png" // register the PNG decoder with image.Decode()
+	"net/http"
+
+	_ "golang.org/x/image/webp" // register the WebP decoder with image.Decode()
+)
+
+// Errors returned by Decode().
+var (
+	ErrUnsupportedFormat = errors.New("imaging: unsupported image format")
+	ErrTooManyPixels     = errors.New("imaging: image has too many pixels")
+	ErrInvalidImage      = errors.New("imaging: invalid image")
+)
+
+// Formats maps the content types of the image formats which Decode() accepts to the
+// usual file extension for each.
+var Formats = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/webp": "webp",
+}
+
+// Decode decodes a JPEG, PNG or WebP image, returning it along with its content type.
+// The format is detected from the data itself, whatever the image claims to be. Images
+// with more than maxPixels pixels are rejected before they are decoded, so that a small
+// but highly compressed file can't make us allocate an enormous image.
+func Decode(data []byte, maxPixels int) (image.Image, string, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := Formats[contentType]; !ok {
+		return nil, "", ErrUnsupportedFormat
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrInvalidImage
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > int64(maxPixels) {
+		return nil, "", ErrTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrInvalidImage
+	}
+	return img, contentType, nil
+}
+
+// Thumbnail scales img down to the given width, keeping its aspect ratio, and encodes it
+// as a JPEG. Images which are already narrower than width are only re-encoded, never
+// scaled up. Transparent areas are filled with white, as JPEG has no transparency.
+func Thumbnail(img image.Image, width int) ([]byte, error) {
+	src := flatten(img)
+
+	b := src.Bounds()
+	if b.Dx() > width {
+		height := b.Dy() * width / b.Dx()
+		if height < 1 {
+			height = 1
+		}
+		src = scale(src, width, height)
+	}
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 85})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// The flatten() helper draws img onto a white RGBA canvas with its top-left corner at
+// the origin.
+func flatten(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
+
+// The scale() helper shrinks src to width x height by averaging the block of source
+// pixels which falls under each destination pixel (a box filter). This is slower than
+// nearest-neighbour sampling but doesn't alias, which matters for covers with text.
+func scale(src *image.RGBA, width, height int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, (y+1)*sh/height
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*sw/width, (x+1)*sw/width
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,120 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// The solid() helper returns a width x height image filled with c.
+func solid(width, height int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encode(t *testing.T, fn func(*bytes.Buffer) error) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	err := fn(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	red := solid(20, 10, color.RGBA{R: 255, A: 255})
+	pngData := encode(t, func(buf *bytes.Buffer) error { return png.Encode(buf, red) })
+	jpegData := encode(t, func(buf *bytes.Buffer) error { return jpeg.Encode(buf, red, nil) })
+	gifData := encode(t, func(buf *bytes.Buffer) error { return gif.Encode(buf, red, nil) })
+
+	tests := []struct {
+		name        string
+		data        []byte
+		maxPixels   int
+		contentType string
+		err         error
+	}{
+		{"png", pngData, 1000, "image/png", nil},
+		{"jpeg", jpegData, 1000, "image/jpeg", nil},
+		{"exactly max pixels", pngData, 200, "image/png", nil},
+		{"too many pixels", pngData, 199, "", ErrTooManyPixels},
+		{"gif", gifData, 1000, "", ErrUnsupportedFormat},
+		{"text", []byte("not an image at all"), 1000, "", ErrUnsupportedFormat},
+		{"empty", nil, 1000, "", ErrUnsupportedFormat},
+		{"truncated png", pngData[:30], 1000, "", ErrInvalidImage},
+		{"webp header only", []byte("RIFF\x10\x00\x00\x00WEBPVP8 \x04\x00\x00\x00junk"), 1000, "", ErrInvalidImage},
+	}
+
+	for _, tt := range tests {
+		img, contentType, err := Decode(tt.data, tt.maxPixels)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: Decode() error = %v; want %v", tt.name, err, tt.err)
+			continue
+		}
+		if contentType != tt.contentType {
+			t.Errorf("%s: Decode() content type = %q; want %q", tt.name, contentType, tt.contentType)
+		}
+		if err == nil && img.Bounds() != red.Bounds() {
+			t.Errorf("%s: Decode() bounds = %v; want %v", tt.name, img.Bounds(), red.Bounds())
+		}
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		thumbWidth    int
+		want          image.Point
+	}{
+		{"scaled down", 600, 900, 100, image.Pt(100, 150)},
+		{"rounded down", 300, 100, 200, image.Pt(200, 66)},
+		{"already narrow", 80, 120, 100, image.Pt(80, 120)},
+		{"same width", 100, 50, 100, image.Pt(100, 50)},
+		{"thin strip", 1000, 2, 100, image.Pt(100, 1)},
+	}
+
+	for _, tt := range tests {
+		src := solid(tt.width, tt.height, color.RGBA{B: 255, A: 255})
+		data, err := Thumbnail(src, tt.thumbWidth)
+		if err != nil {
+			t.Errorf("%s: Thumbnail() error: %v", tt.name, err)
+			continue
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%s: Thumbnail() isn't a JPEG: %v", tt.name, err)
+			continue
+		}
+		if got := img.Bounds().Size(); got != tt.want {
+			t.Errorf("%s: thumbnail size = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// JPEG has no transparency, so transparent areas come out white rather than black.
+func TestThumbnailFlattensTransparency(t *testing.T) {
+	data, err := Thumbnail(image.NewNRGBA(image.Rect(0, 0, 40, 40)), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, g, b, _ := img.At(10, 10).RGBA()
+	if r < 0xf000 || g < 0xf000 || b < 0xf000 {
+		t.Errorf("transparent pixel came out as %v; want white", img.At(10, 10))
+	}
+}
@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory keeps the request counts in process memory. Each instance of the application
+// then has limits of its own, so it suits a single instance best.
+type Memory struct {
+	mu        sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+type window struct {
+	count int64
+	ends  time.Time
+}
+
+// NewMemory returns an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{windows: make(map[string]*window), lastSweep: time.Now()}
+}
+
+func (m *Memory) Take(ctx context.Context, key string, period time.Duration) (int64, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	// Once a minute, remove the windows which have ended, so that clients which have
+	// gone away don't stay in the map.
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, w := range m.windows {
+			if !now.Before(w.ends) {
+				delete(m.windows, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	w, ok := m.windows[key]
+	if !ok || !now.Before(w.ends) {
+		w = &window{ends: now.Add(period)}
+		m.windows[key] = w
+	}
+	w.count++
+	return w.count, w.ends.Sub(now), nil
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Store counts requests in fixed windows of time. Every instance of the application
+// which shares a store also shares its limits.
+type Store interface {
+	// Take counts a request against key, in the window of the given length which is
+	// current. It returns the number of requests counted in the window so far,
+	// including this one, and how long it is until the window ends.
+	Take(ctx context.Context, key string, window time.Duration) (count int64, reset time.Duration, err error)
+}
+
+// A Quota allows Limit requests per Period.
+type Quota struct {
+	Limit  int64
+	Period time.Duration
+}
+
+// A Policy holds the quotas for a group of routes. Anonymous clients are limited by IP
+// address. Authenticated users are limited by user id, with the User quota, and requests
+// which carry a token are also limited by IP address with the User quota before the
+// token is checked. If the User quota is zero, everybody is limited by IP address.
+type Policy struct {
+	Name      string
+	Anonymous Quota
+	User      Quota
+}
+
+// The String() method returns the policy in the form read by ParsePolicy().
+func (p Policy) String() string {
+	if p.User.Limit == 0 {
+		return fmt.Sprintf("%d/%s", p.Anonymous.Limit, p.Anonymous.Period)
+	}
+	return fmt.Sprintf("%d,%d/%s", p.Anonymous.Limit, p.User.Limit, p.Anonymous.Period)
+}
+
+// ParsePolicy reads a policy written as "<anonymous>/<period>" or
+// "<anonymous>,<user>/<period>", such as "60,600/1m" for 60 requests a minute per IP
+// address and 600 a minute per authenticated user.
+func ParsePolicy(name, s string) (Policy, error) {
+	limits, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Policy{}, fmt.Errorf("rate limit %q: must have the form <limit>/<period> or <limit>,<user limit>/<period>", s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Policy{}, fmt.Errorf("rate limit %q: invalid period %q", s, period)
+	}
+
+	policy := Policy{Name: name}
+	anonymous, user, hasUser := strings.Cut(limits, ",")
+	policy.Anonymous.Limit, err = strconv.ParseInt(anonymous, 10, 64)
+	if err != nil || policy.Anonymous.Limit < 1 {
+		return Policy{}, fmt.Errorf("rate limit %q: invalid limit %q", s, anonymous)
+	}
+	policy.Anonymous.Period = d
+	if hasUser {
+		policy.User.Limit, err = strconv.ParseInt(user, 10, 64)
+		if err != nil || policy.User.Limit < 1 {
+			return Policy{}, fmt.Errorf("rate limit %q: invalid user limit %q", s, user)
+		}
+		policy.User.Period = d
+	}
+	return policy, nil
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Policy
+		wantErr bool
+	}{
+		{value: "10/1m", want: Policy{Name: "p", Anonymous: Quota{10, time.Minute}}},
+		{value: "60,600/1m", want: Policy{Name: "p", Anonymous: Quota{60, time.Minute}, User: Quota{600, time.Minute}}},
+		{value: "5/30s", want: Policy{Name: "p", Anonymous: Quota{5, 30 * time.Second}}},
+		{value: "10", wantErr: true},
+		{value: "10/", wantErr: true},
+		{value: "10/soon", wantErr: true},
+		{value: "10/-1m", wantErr: true},
+		{value: "0/1m", wantErr: true},
+		{value: "ten/1m", wantErr: true},
+		{value: "10,/1m", wantErr: true},
+		{value: "10,0/1m", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePolicy("p", tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParsePolicy(%q) = %+v; want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePolicy(%q) error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePolicy(%q) = %+v; want %+v", tt.value, got, tt.want)
+		}
+		// String() writes the policy back in the same form.
+		again, err := ParsePolicy("p", got.String())
+		if err != nil || again != got {
+			t.Errorf("ParsePolicy(%q.String() = %q) = %+v, %v", tt.value, got.String(), again, err)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	for want := int64(1); want <= 3; want++ {
+		count, reset, err := m.Take(ctx, "a", time.Minute)
+		if err != nil || count != want {
+			t.Fatalf("Take(a) = %d, %v; want %d", count, err, want)
+		}
+		if reset <= 0 || reset > time.Minute {
+			t.Errorf("Take(a) reset = %v; want within the window", reset)
+		}
+	}
+	if count, _, _ := m.Take(ctx, "b", time.Minute); count != 1 {
+		t.Errorf("Take(b) = %d; want 1, as keys are counted separately", count)
+	}
+
+	// A new window starts once the old one has ended.
+	m.Take(ctx, "c", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if count, _, _ := m.Take(ctx, "c", time.Millisecond); count != 1 {
+		t.Errorf("Take(c) in a new window = %d; want 1", count)
+	}
+}
@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"context"
+	"finalProjectAdvancedP/internal/cache"
+	"fmt"
+	"time"
+)
+
+// takeScript counts a request in a window, starting the window if the key is new. It
+// runs as a script, so that the count and expiry are set together even when several
+// instances of the application count against the same key.
+const takeScript = `
+local count = redis.call('INCR', KEYS[1])
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {count, ttl}`
+
+// Redis keeps the request counts in a Redis server (or one which speaks the same
+// protocol, such as Valkey or KeyDB), so that they are shared by every instance of the
+// application.
+type Redis struct {
+	client *cache.Redis
+	prefix string
+}
+
+// NewRedis returns a store which keeps its counts in client, under keys with the given
+// prefix.
+func NewRedis(client *cache.Redis, prefix string) *Redis {
+	return &Redis{client: client, prefix: prefix}
+}
+
+func (s *Redis) Take(ctx context.Context, key string, period time.Duration) (int64, time.Duration, error) {
+	reply, err := s.client.Do(ctx, "EVAL", takeScript, 1, s.prefix+key, period.Milliseconds())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	items, ok := reply.([]any)
+	if !ok || len(items) != 2 {
+		return 0, 0, fmt.Errorf("ratelimit: unexpected reply from Redis: %v", reply)
+	}
+	count, ok1 := items[0].(int64)
+	ttl, ok2 := items[1].(int64)
+	if !ok1 || !ok2 {
+		return 0, 0, fmt.Errorf("ratelimit: unexpected reply from Redis: %v", reply)
+	}
+	return count, time.Duration(ttl) * time.Millisecond, nil
+}
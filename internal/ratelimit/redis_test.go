@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"finalProjectAdvancedP/internal/cache"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The newEvalStandIn() helper starts a server which stands in for Redis, answering EVAL
+// of the take script as Redis would: counting the key, and keeping the expiry of its
+// first request. It returns the server's address.
+func newEvalStandIn(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	counts := make(map[string]int64)
+	ttls := make(map[string]string)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+
+		for {
+			var args []string
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			for i := 0; i < n; i++ {
+				line, err = r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+				b := make([]byte, size+2)
+				if _, err = io.ReadFull(r, b); err != nil {
+					return
+				}
+				args = append(args, string(b[:size]))
+			}
+
+			reply := "-ERR unexpected command\r\n"
+			if len(args) == 5 && args[0] == "EVAL" && args[1] == takeScript && args[2] == "1" {
+				key := args[3]
+				counts[key]++
+				if _, ok := ttls[key]; !ok {
+					ttls[key] = args[4]
+				}
+				reply = fmt.Sprintf("*2\r\n:%d\r\n:%s\r\n", counts[key], ttls[key])
+			}
+			if _, err = io.WriteString(conn, reply); err != nil {
+				return
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestRedis(t *testing.T) {
+	client, err := cache.NewRedis("redis://"+newEvalStandIn(t), "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	store := NewRedis(client, "bookstore:ratelimit:")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for want := int64(1); want <= 2; want++ {
+		count, reset, err := store.Take(ctx, "read:ip:203.0.113.7", time.Minute)
+		if err != nil {
+			t.Fatalf("Take() error: %v", err)
+		}
+		if count != want || reset != time.Minute {
+			t.Errorf("Take() = %d, %v; want %d, %v", count, reset, want, time.Minute)
+		}
+	}
+}
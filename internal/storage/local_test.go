@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "covers")
+	l, err := NewLocal(dir, "http://localhost:4000/v1/covers/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	key := "books/12/3f9a2c/original.jpg"
+	err = l.Put(ctx, key, []byte("first"), "image/jpeg")
+	if err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+	// Putting the same key again replaces the file.
+	err = l.Put(ctx, key, []byte("second"), "image/jpeg")
+	if err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+
+	name := filepath.Join(dir, "books", "12", "3f9a2c", "original.jpg")
+	data, err := os.ReadFile(name)
+	if err != nil || string(data) != "second" {
+		t.Fatalf("stored file = %q, %v; want second", data, err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o644 {
+		t.Errorf("stored file has mode %v; want 0644", perm)
+	}
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(filepath.Dir(name))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("directory holds %d entries (%v); want only the stored file", len(entries), err)
+	}
+
+	if got, want := l.URL(key), "http://localhost:4000/v1/covers/"+key; got != want {
+		t.Errorf("URL() = %q; want %q", got, want)
+	}
+
+	err = l.Delete(ctx, key)
+	if err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file still exists after Delete(): %v", err)
+	}
+	// Deleting a file which doesn't exist is not an error.
+	err = l.Delete(ctx, key)
+	if err != nil {
+		t.Errorf("Delete() of a missing file = %v; want nil", err)
+	}
+}
+
+// Keys which aren't clean relative paths could reach outside the storage directory, so
+// they are refused.
+func TestLocalInvalidKeys(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "covers")
+	l, err := NewLocal(dir, "http://localhost:4000/v1/covers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// A file next to the storage directory, which a traversal could reach.
+	outside := filepath.Join(root, "secret.txt")
+	err = os.WriteFile(outside, []byte("secret"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{
+		"",
+		"/",
+		"../secret.txt",
+		"books/../../secret.txt",
+		"books/../x.jpg",
+		"/books/1/original.jpg",
+		"./books/1/original.jpg",
+		"books//1/original.jpg",
+		"books/1/",
+	}
+	for _, key := range keys {
+		err := l.Put(ctx, key, []byte("overwritten"), "image/jpeg")
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q) = %v; want ErrInvalidKey", key, err)
+		}
+		err = l.Delete(ctx, key)
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q) = %v; want ErrInvalidKey", key, err)
+		}
+	}
+
+	data, err := os.ReadFile(outside)
+	if err != nil || string(data) != "secret" {
+		t.Errorf("file outside the storage directory = %q, %v; want it untouched", data, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("storage directory holds %d entries (%v); want none", len(entries), err)
+	}
+}
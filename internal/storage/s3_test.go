@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// An s3Request is a request received by the stand-in S3 server.
+type s3Request struct {
+	method      string
+	path        string
+	contentType string
+	body        []byte
+	signedBy    string // access key the signature checked out for, or "" if it didn't
+	signed      string // SignedHeaders from the Authorization header
+}
+
+// The newS3StandIn() helper starts a server which stands in for S3. It checks the
+// signature of every request against the secret key, records the request and answers
+// with the given status.
+func newS3StandIn(t *testing.T, accessKey, secretKey string, status int) (*httptest.Server, func() []s3Request) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var requests []s3Request
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req := s3Request{
+			method:      r.Method,
+			path:        r.URL.EscapedPath(),
+			contentType: r.Header.Get("Content-Type"),
+			body:        body,
+		}
+
+		var signature string
+		for _, field := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 "), ", ") {
+			name, value, _ := strings.Cut(field, "=")
+			switch name {
+			case "SignedHeaders":
+				req.signed = value
+			case "Signature":
+				signature = value
+			}
+		}
+		if verifySigV4(r, body, accessKey, secretKey, req.signed, signature) {
+			req.signedBy = accessKey
+		}
+
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []s3Request {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]s3Request(nil), requests...)
+	}
+}
+
+// The verifySigV4() function recomputes the AWS Signature Version 4 of a request from
+// what the server received, independently of S3.sign().
+func verifySigV4(r *http.Request, body []byte, accessKey, secretKey, signedHeaders, signature string) bool {
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != len("20060102T150405Z") {
+		return false
+	}
+	date := amzDate[:8]
+
+	sum := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		return false
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonicalRequest := r.Method + "\n" + r.URL.EscapedPath() + "\n\n" + canonicalHeaders.String() + "\n" +
+		signedHeaders + "\n" + r.Header.Get("X-Amz-Content-Sha256")
+
+	scope := date + "/us-east-1/s3/aws4_request"
+	if !strings.Contains(r.Header.Get("Authorization"), "Credential="+accessKey+"/"+scope+",") {
+		return false
+	}
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, "us-east-1")
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign)) == signature
+}
+
+func TestS3PutAndDelete(t *testing.T) {
+	srv, requests := newS3StandIn(t, "AKIDEXAMPLE", "secret", http.StatusOK)
+
+	s3, err := NewS3(S3Config{
+		Endpoint:  srv.URL,
+		Bucket:    "covers",
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = s3.Put(ctx, "books/1/ab12/original.jpg", []byte("jpeg data"), "image/jpeg")
+	if err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+	err = s3.Delete(ctx, "books/1/ab12/small name.jpg")
+	if err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+
+	want := []s3Request{
+		{
+			method:      http.MethodPut,
+			path:        "/covers/books/1/ab12/original.jpg",
+			contentType: "image/jpeg",
+			body:        []byte("jpeg data"),
+			signedBy:    "AKIDEXAMPLE",
+			signed:      "content-type;host;x-amz-content-sha256;x-amz-date",
+		},
+		{
+			method:   http.MethodDelete,
+			path:     "/covers/books/1/ab12/small%20name.jpg",
+			signedBy: "AKIDEXAMPLE",
+			signed:   "host;x-amz-content-sha256;x-amz-date",
+		},
+	}
+
+	got := requests()
+	if len(got) != len(want) {
+		t.Fatalf("got %d requests; want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.method != w.method || g.path != w.path || g.contentType != w.contentType || string(g.body) != string(w.body) {
+			t.Errorf("request %d = %s %s (%q, %q); want %s %s (%q, %q)", i, g.method, g.path, g.contentType, g.body, w.method, w.path, w.contentType, w.body)
+		}
+		if g.signed != w.signed {
+			t.Errorf("request %d signed headers = %q; want %q", i, g.signed, w.signed)
+		}
+		if g.signedBy != w.signedBy {
+			t.Errorf("request %d has an invalid signature", i)
+		}
+	}
+
+	if got, want := s3.URL("books/1/ab12/original.jpg"), srv.URL+"/covers/books/1/ab12/original.jpg"; got != want {
+		t.Errorf("URL() = %q; want %q", got, want)
+	}
+}
+
+func TestS3Errors(t *testing.T) {
+	srv, requests := newS3StandIn(t, "AKIDEXAMPLE", "secret", http.StatusForbidden)
+
+	s3, err := NewS3(S3Config{Endpoint: srv.URL, Bucket: "covers", AccessKey: "AKIDEXAMPLE", SecretKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s3.Put(context.Background(), "books/1/original.png", []byte("png"), "image/png")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Put() error = %v; want a 403 error", err)
+	}
+
+	for _, key := range []string{"", "books/../secret"} {
+		err = s3.Delete(context.Background(), key)
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q) error = %v; want ErrInvalidKey", key, err)
+		}
+	}
+	if n := len(requests()); n != 1 {
+		t.Errorf("server got %d requests; want 1", n)
+	}
+
+	_, err = NewS3(S3Config{Endpoint: "localhost:9000", Bucket: "covers"})
+	if err == nil {
+		t.Error("NewS3() accepted an endpoint without a scheme")
+	}
+	_, err = NewS3(S3Config{Endpoint: srv.URL})
+	if err == nil {
+		t.Error("NewS3() accepted a config without a bucket")
+	}
+}
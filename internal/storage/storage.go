@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrInvalidKey is returned when a key would escape the storage area, for example
+// because it contains "..".
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// Storage is a place to keep uploaded files, such as book covers. Keys are slash
+// separated paths like "books/12/3f9a2c/original.jpg", and every stored file is
+// publicly readable at the address returned by URL().
+type Storage interface {
+	// Put stores the data under key, replacing any existing file.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Delete removes the file stored under key. Deleting a file which doesn't exist is
+	// not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the public address of the file stored under key.
+	URL(key string) string
+}
@@ -0,0 +1,89 @@
+package validator
+
+import "strings"
+
+// NormalizeISBN strips the hyphens and spaces which are commonly used to group the
+// digits of an ISBN, and upper-cases the 'x' check digit of an ISBN-10.
+func NormalizeISBN(isbn string) string {
+	isbn = strings.NewReplacer("-", "", " ", "").Replace(isbn)
+	return strings.ToUpper(isbn)
+}
+
+// ValidISBN10 returns true if a normalized string is an ISBN-10 with a correct check
+// digit. The check digit (which may be 'X', standing for 10) makes the sum of the
+// digits weighted 10 down to 1 divisible by 11.
+func ValidISBN10(isbn string) bool {
+	if len(isbn) != 10 {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 10; i++ {
+		c := isbn[i]
+		var digit int
+		switch {
+		case c >= '0' && c <= '9':
+			digit = int(c - '0')
+		case c == 'X' && i == 9:
+			digit = 10
+		default:
+			return false
+		}
+		sum += digit * (10 - i)
+	}
+	return sum%11 == 0
+}
+
+// ValidISBN13 returns true if a normalized string is an ISBN-13 with a correct check
+// digit. The check digit makes the sum of the digits, weighted alternately 1 and 3,
+// divisible by 10.
+func ValidISBN13(isbn string) bool {
+	if len(isbn) != 13 || !(strings.HasPrefix(isbn, "978") || strings.HasPrefix(isbn, "979")) {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 13; i++ {
+		c := isbn[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		sum += int(c-'0') * (1 + 2*(i%2))
+	}
+	return sum%10 == 0
+}
+
+// ISBN10To13 converts a valid ISBN-10 into the equivalent ISBN-13, by prefixing it with
+// 978 and recalculating the check digit.
+func ISBN10To13(isbn string) (string, bool) {
+	if !ValidISBN10(isbn) {
+		return "", false
+	}
+
+	body := "978" + isbn[:9]
+	sum := 0
+	for i := 0; i < 12; i++ {
+		sum += int(body[i]-'0') * (1 + 2*(i%2))
+	}
+	check := (10 - sum%10) % 10
+	return body + string(rune('0'+check)), true
+}
+
+// ISBN13To10 converts a valid ISBN-13 into the equivalent ISBN-10. Only ISBN-13s in the
+// 978 range have an ISBN-10 equivalent.
+func ISBN13To10(isbn string) (string, bool) {
+	if !ValidISBN13(isbn) || !strings.HasPrefix(isbn, "978") {
+		return "", false
+	}
+
+	body := isbn[3:12]
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(body[i]-'0') * (10 - i)
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return body + "X", true
+	}
+	return body + string(rune('0'+check)), true
+}
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS books_year_idx;
+DROP INDEX IF EXISTS books_price_idx;
+DROP INDEX IF EXISTS books_search_idx;
+ALTER TABLE books DROP COLUMN IF EXISTS search;
@@ -0,0 +1,9 @@
+ALTER TABLE books
+    ADD COLUMN IF NOT EXISTS search tsvector
+        GENERATED ALWAYS AS (
+            setweight(to_tsvector('simple', title), 'A') ||
+            setweight(to_tsvector('simple', author), 'B')
+        ) STORED;
+CREATE INDEX IF NOT EXISTS books_search_idx ON books USING GIN (search);
+CREATE INDEX IF NOT EXISTS books_price_idx ON books (price);
+CREATE INDEX IF NOT EXISTS books_year_idx ON books (year);
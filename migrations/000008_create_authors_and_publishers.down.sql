@@ -0,0 +1,4 @@
+ALTER TABLE books DROP COLUMN IF EXISTS publisher_id;
+DROP TABLE IF EXISTS book_authors;
+DROP TABLE IF EXISTS publishers;
+DROP TABLE IF EXISTS authors;
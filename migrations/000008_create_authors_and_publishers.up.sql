@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS authors (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    bio text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+CREATE UNIQUE INDEX IF NOT EXISTS authors_name_key ON authors (lower(name));
+
+CREATE TABLE IF NOT EXISTS publishers (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    website text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+CREATE UNIQUE INDEX IF NOT EXISTS publishers_name_key ON publishers (lower(name));
+
+CREATE TABLE IF NOT EXISTS book_authors (
+    book_id bigint NOT NULL REFERENCES books ON DELETE CASCADE,
+    author_id bigint NOT NULL REFERENCES authors ON DELETE RESTRICT,
+    position integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (book_id, author_id)
+);
+CREATE INDEX IF NOT EXISTS book_authors_author_id_idx ON book_authors (author_id);
+
+ALTER TABLE books ADD COLUMN IF NOT EXISTS publisher_id bigint REFERENCES publishers ON DELETE SET NULL;
+
+-- Back-fill one author per distinct (case-insensitive) books.author value, and link
+-- every existing book to its author. books.author is kept as the display string.
+INSERT INTO authors (name)
+SELECT DISTINCT ON (lower(trim(author))) trim(author)
+FROM books
+WHERE trim(author) <> ''
+ORDER BY lower(trim(author)), trim(author)
+ON CONFLICT DO NOTHING;
+
+INSERT INTO book_authors (book_id, author_id, position)
+SELECT books.id, authors.id, 0
+FROM books
+INNER JOIN authors ON lower(authors.name) = lower(trim(books.author))
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,4 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS isbn10 text;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS isbn13 text;
+CREATE UNIQUE INDEX IF NOT EXISTS books_isbn10_key ON books (isbn10);
+CREATE UNIQUE INDEX IF NOT EXISTS books_isbn13_key ON books (isbn13);
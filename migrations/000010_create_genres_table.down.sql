@@ -0,0 +1,2 @@
+DROP VIEW IF EXISTS genre_descendants;
+DROP TABLE IF EXISTS genres;
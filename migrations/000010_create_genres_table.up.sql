@@ -0,0 +1,45 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    slug text NOT NULL,
+    name text NOT NULL,
+    parent_id bigint REFERENCES genres ON DELETE RESTRICT,
+    version integer NOT NULL DEFAULT 1
+);
+CREATE UNIQUE INDEX IF NOT EXISTS genres_slug_key ON genres (slug);
+CREATE INDEX IF NOT EXISTS genres_parent_id_idx ON genres (parent_id);
+
+-- genre_descendants pairs every genre with itself and each of the genres below it, so
+-- that filtering by a parent genre also matches books in its children. UNION (rather
+-- than UNION ALL) stops the recursion even if a cycle somehow gets into the table.
+CREATE OR REPLACE VIEW genre_descendants (ancestor, slug) AS
+WITH RECURSIVE tree (ancestor, id, slug) AS (
+    SELECT slug, id, slug FROM genres
+    UNION
+    SELECT tree.ancestor, genres.id, genres.slug
+    FROM genres
+    INNER JOIN tree ON genres.parent_id = tree.id
+)
+SELECT ancestor, slug FROM tree;
+
+-- Back-fill one genre per distinct slug used by existing books, and rewrite the books'
+-- genres as slugs. Books now store genre slugs, which always refer to this table.
+INSERT INTO genres (slug, name)
+SELECT DISTINCT ON (slug) slug, name
+FROM (
+    SELECT lower(regexp_replace(trim(g), '[\s_]+', '-', 'g')) AS slug, trim(g) AS name
+    FROM books, unnest(genres) AS g
+) AS used
+WHERE slug <> ''
+ORDER BY slug, name
+ON CONFLICT DO NOTHING;
+
+UPDATE books
+SET genres = ARRAY(
+    SELECT slug
+    FROM unnest(books.genres) WITH ORDINALITY AS g(name, position),
+        LATERAL (SELECT lower(regexp_replace(trim(g.name), '[\s_]+', '-', 'g')) AS slug) AS s
+    WHERE slug <> ''
+    GROUP BY slug
+    ORDER BY min(position)
+);
@@ -0,0 +1,11 @@
+ALTER TABLE carts DROP CONSTRAINT IF EXISTS carts_book_id_fkey;
+ALTER TABLE carts ADD CONSTRAINT carts_book_id_fkey FOREIGN KEY (book_id) REFERENCES books ON DELETE CASCADE;
+
+DROP INDEX IF EXISTS books_isbn10_key;
+DROP INDEX IF EXISTS books_isbn13_key;
+DELETE FROM books WHERE deleted_at IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS books_isbn10_key ON books (isbn10);
+CREATE UNIQUE INDEX IF NOT EXISTS books_isbn13_key ON books (isbn13);
+
+DROP INDEX IF EXISTS books_deleted_at_idx;
+ALTER TABLE books DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,14 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+CREATE INDEX IF NOT EXISTS books_deleted_at_idx ON books (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Deleted books keep their ISBNs, but a new book may reuse them.
+DROP INDEX IF EXISTS books_isbn10_key;
+DROP INDEX IF EXISTS books_isbn13_key;
+CREATE UNIQUE INDEX IF NOT EXISTS books_isbn10_key ON books (isbn10) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS books_isbn13_key ON books (isbn13) WHERE deleted_at IS NULL;
+
+-- Books are no longer deleted from under the carts and orders that refer to them. The
+-- purge job removes a deleted book's abandoned cart rows itself, and leaves books
+-- which have been ordered alone.
+ALTER TABLE carts DROP CONSTRAINT IF EXISTS carts_book_id_fkey;
+ALTER TABLE carts ADD CONSTRAINT carts_book_id_fkey FOREIGN KEY (book_id) REFERENCES books ON DELETE RESTRICT;
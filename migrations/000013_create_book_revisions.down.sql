@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS book_revisions;
+DROP FUNCTION IF EXISTS book_snapshot(books);
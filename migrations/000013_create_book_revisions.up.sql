@@ -0,0 +1,46 @@
+-- book_snapshot() returns the fields of a book which are kept in its revision history.
+-- The author ids are included so that a revision can be reverted to exactly.
+CREATE OR REPLACE FUNCTION book_snapshot(book books) RETURNS jsonb
+LANGUAGE sql STABLE AS $$
+    SELECT jsonb_build_object(
+        'title', book.title,
+        'year', book.year,
+        'author', book.author,
+        'author_ids', (
+            SELECT COALESCE(jsonb_agg(author_id ORDER BY position), '[]')
+            FROM book_authors
+            WHERE book_authors.book_id = book.id
+        ),
+        'genres', book.genres,
+        'price', book.price,
+        'publisher_id', book.publisher_id,
+        'isbn10', book.isbn10,
+        'isbn13', book.isbn13,
+        'cover_url', book.cover_url,
+        'deleted_at', book.deleted_at
+    )
+$$;
+
+-- Revisions outlive the books they belong to, so that the history of a purged book can
+-- still be looked up, and are kept when the acting user is deleted.
+CREATE TABLE IF NOT EXISTS book_revisions (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    book_id bigint NOT NULL,
+    version integer NOT NULL,
+    action text NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    reverted_from bigint REFERENCES book_revisions ON DELETE SET NULL,
+    snapshot jsonb NOT NULL,
+    changes jsonb NOT NULL
+);
+CREATE INDEX IF NOT EXISTS book_revisions_book_id_idx ON book_revisions (book_id, id);
+
+-- Start every existing book's history with its current state, as if it had just been
+-- created, so that the first change made to it is diffed against something.
+INSERT INTO book_revisions (created_at, book_id, version, action, snapshot, changes)
+SELECT books.created_at, books.id, books.version, 'create', snapshot.data,
+    (SELECT jsonb_object_agg(key, jsonb_build_object('from', NULL, 'to', value)) FROM jsonb_each(snapshot.data))
+FROM books
+CROSS JOIN LATERAL (SELECT book_snapshot(books) AS data) AS snapshot
+ORDER BY books.id;
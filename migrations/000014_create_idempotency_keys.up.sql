@@ -0,0 +1,18 @@
+-- The first response to a request made with an Idempotency-Key header is kept here, so
+-- that retries of the request get the same response instead of repeating its effects.
+-- status is NULL while the first request is still being processed. Anonymous requests
+-- are stored with a user_id of 0.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id bigint NOT NULL,
+    key text NOT NULL,
+    method text NOT NULL,
+    path text NOT NULL,
+    request_hash bytea NOT NULL,
+    status integer,
+    header jsonb,
+    body bytea,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expires_at timestamp(0) with time zone NOT NULL,
+    PRIMARY KEY (user_id, key, method, path)
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS genres_updated_at_idx;
+DROP INDEX IF EXISTS publishers_updated_at_idx;
+DROP INDEX IF EXISTS authors_updated_at_idx;
+ALTER TABLE genres DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE publishers DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE authors DROP COLUMN IF EXISTS updated_at;
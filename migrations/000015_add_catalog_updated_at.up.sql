@@ -0,0 +1,9 @@
+-- Books embed their authors, publisher and genre names, which can change without the
+-- books themselves changing. updated_at records when each was last edited, so that
+-- lists of books can tell when the catalog as a whole last changed.
+ALTER TABLE authors ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+ALTER TABLE publishers ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+ALTER TABLE genres ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+CREATE INDEX IF NOT EXISTS authors_updated_at_idx ON authors (updated_at);
+CREATE INDEX IF NOT EXISTS publishers_updated_at_idx ON publishers (updated_at);
+CREATE INDEX IF NOT EXISTS genres_updated_at_idx ON genres (updated_at);
@@ -0,0 +1,4 @@
+DELETE FROM idempotency_keys WHERE client <> '';
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (user_id, key, method, path);
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS client;
@@ -0,0 +1,5 @@
+-- Anonymous requests all have a user_id of 0, so their keys are also scoped by the
+-- client which sent them. client is empty for authenticated requests.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS client text NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (user_id, client, key, method, path);